@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func getNinjaStampFile(ninjaFile string) string {
+	return ninjaFile + ".stamp"
+}
+
+// getNinjaStamp gets a text that identifies the arguments used to generate the ninja file.
+func getNinjaStamp(manifestFile string, env *Environment) string {
+	if abs, err := filepath.Abs(manifestFile); err == nil {
+		manifestFile = abs
+	}
+	str := fmt.Sprintln("manifest =", manifestFile)
+	str += fmt.Sprintln("out_dir =", env.OutDir)
+	str += fmt.Sprintln("tags =", strings.Join(env.Tags, " "))
+	str += fmt.Sprintln("features =", strings.Join(env.Features, " "))
+	str += fmt.Sprintln("install_prefix =", env.InstallPrefix)
+	if env.Toolchain != nil {
+		str += fmt.Sprintln("toolchain =", env.Toolchain.Name)
+		str += fmt.Sprintln("compiler_launcher =", env.Toolchain.CompilerLauncher)
+	}
+	if env.Platform != nil {
+		str += fmt.Sprintln("platform =", env.Platform.Name)
+	}
+	if env.Host != nil {
+		str += fmt.Sprintln("host_tags =", strings.Join(env.Host.Tags, " "))
+		str += fmt.Sprintln("host_toolchain =", env.Host.Toolchain.Name)
+	}
+	return str
+}
+
+func writeNinjaStamp(ninjaFile, stamp string) error {
+	return ioutil.WriteFile(getNinjaStampFile(ninjaFile), []byte(stamp), 0644)
+}
+
+func needsRegenerateNinja(ninjaFile, stamp string, graph *Graph) bool {
+	info, err := os.Stat(ninjaFile)
+	if err != nil {
+		return true
+	}
+
+	content, err := ioutil.ReadFile(getNinjaStampFile(ninjaFile))
+	if err != nil || string(content) != stamp {
+		return true
+	}
+
+	for _, manifestFile := range graph.ManifestFiles {
+		manifestInfo, err := os.Stat(manifestFile)
+		if err != nil || manifestInfo.ModTime().After(info.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+func getNodeOutputs(env *Environment, node *Node) (result []string) {
+	switch node.Type {
+	case OutputTypeExecutable, OutputTypeTest:
+		result = append(result, getExecutableFile(env, node))
+	case OutputTypeStaticLibrary:
+		result = append(result, getStaticLibraryFile(env, node))
+	case OutputTypeAction:
+		result = append(result, getActionOutputs(env, node)...)
+	}
+	return result
+}
+
+// getNinjaTargets maps target names in manifests to the output paths in the ninja file.
+func getNinjaTargets(env *Environment, graph *Graph, names []string) (result []string) {
+	nodes := map[string]*Node{}
+	for _, node := range graph.Nodes {
+		nodes[node.Name] = node
+	}
+	envs := getNodeEnvironments(env, graph.Nodes)
+
+	for _, name := range names {
+		_, targetName := splitManifestTarget(name)
+		if node, ok := nodes[targetName]; ok {
+			result = append(result, getNodeOutputs(envs[node], node)...)
+			continue
+		}
+		// NOTE: Pass through the file paths and the targets that ninja knows.
+		result = append(result, name)
+	}
+	return result
+}
+
+func runNinja(ninjaFile string, targets []string, jobs int) error {
+	args := []string{"-f", ninjaFile}
+	if jobs > 0 {
+		args = append(args, "-j", strconv.Itoa(jobs))
+	}
+	args = append(args, targets...)
+
+	cmd := exec.Command("ninja", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func getExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	// NOTE: ExitCode returns -1 if the process is killed by a signal.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
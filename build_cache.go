@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const buildCacheMaxEntries = 16
+
+// BuildCache stores compiled outputs keyed by the hash of the compile command,
+// the source file and the included headers.
+type BuildCache struct {
+	Dir     string
+	MaxSize int64
+
+	mutex  sync.Mutex
+	hashes map[string]buildCacheFileHash
+}
+
+type buildCacheFileHash struct {
+	ModTime time.Time
+	Size    int64
+	Hash    string
+}
+
+// buildCacheEntry records the headers that a compile command included and the object it produced.
+type buildCacheEntry struct {
+	Files  map[string]string `json:"files"`
+	Object string            `json:"object"`
+	Deps   []string          `json:"deps"`
+}
+
+type buildCacheManifest struct {
+	Entries []buildCacheEntry `json:"entries"`
+}
+
+func (cache *BuildCache) hashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	cache.mutex.Lock()
+	if cache.hashes == nil {
+		cache.hashes = map[string]buildCacheFileHash{}
+	}
+	h, ok := cache.hashes[path]
+	cache.mutex.Unlock()
+	if ok && h.ModTime.Equal(info.ModTime()) && h.Size == info.Size() {
+		return h.Hash, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	cache.mutex.Lock()
+	cache.hashes[path] = buildCacheFileHash{ModTime: info.ModTime(), Size: info.Size(), Hash: hash}
+	cache.mutex.Unlock()
+	return hash, nil
+}
+
+func hashStrings(strs ...string) string {
+	hasher := sha256.New()
+	for _, s := range strs {
+		io.WriteString(hasher, s)
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// getKey gets the key of the compile command and its direct inputs. The output path is
+// removed from the command so that the same compiles in other output directories share the key.
+func (cache *BuildCache) getKey(command string, inputs []string, output string) (string, error) {
+	if len(output) > 0 {
+		command = strings.Replace(command, output, "$out", -1)
+	}
+	strs := []string{command}
+	for _, input := range inputs {
+		hash, err := cache.hashFile(input)
+		if err != nil {
+			return "", err
+		}
+		strs = append(strs, input, hash)
+	}
+	return hashStrings(strs...), nil
+}
+
+func (cache *BuildCache) getManifestFile(key string) string {
+	return filepath.Join(cache.Dir, "manifests", key[:2], key)
+}
+
+func (cache *BuildCache) getObjectFile(key string) string {
+	return filepath.Join(cache.Dir, "objects", key[:2], key)
+}
+
+func (cache *BuildCache) readManifest(key string) *buildCacheManifest {
+	manifest := &buildCacheManifest{}
+	content, err := ioutil.ReadFile(cache.getManifestFile(key))
+	if err != nil {
+		return manifest
+	}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return &buildCacheManifest{}
+	}
+	return manifest
+}
+
+func writeFileAtomically(filename string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "Failed to create cache directory \"%s\"", dir)
+	}
+	file, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+func copyFile(dst, src string) error {
+	return writeFileAtomically(dst, func(w io.Writer) error {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	})
+}
+
+// Restore copies the cached output of the compile command to the output file.
+// It returns the headers that the cached output depends on.
+func (cache *BuildCache) Restore(command string, inputs []string, output string) ([]string, bool) {
+	key, err := cache.getKey(command, inputs, output)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, entry := range cache.readManifest(key).Entries {
+		matched := true
+		for path, hash := range entry.Files {
+			if h, err := cache.hashFile(path); err != nil || h != hash {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		object := cache.getObjectFile(entry.Object)
+		if err := copyFile(output, object); err != nil {
+			continue
+		}
+		// NOTE: Touch the object to keep it from the eviction.
+		now := time.Now()
+		os.Chtimes(object, now, now)
+		return entry.Deps, true
+	}
+	return nil, false
+}
+
+// Store stores the output of the compile command and the headers it depends on.
+func (cache *BuildCache) Store(command string, inputs []string, output string, deps []string) error {
+	key, err := cache.getKey(command, inputs, output)
+	if err != nil {
+		return err
+	}
+
+	entry := buildCacheEntry{
+		Files: map[string]string{},
+		Deps:  deps,
+	}
+	strs := []string{key}
+	for _, dep := range deps {
+		hash, err := cache.hashFile(dep)
+		if err != nil {
+			return err
+		}
+		entry.Files[dep] = hash
+		strs = append(strs, dep, hash)
+	}
+	entry.Object = hashStrings(strs...)
+
+	if err := copyFile(cache.getObjectFile(entry.Object), output); err != nil {
+		return err
+	}
+
+	manifest := cache.readManifest(key)
+	entries := []buildCacheEntry{entry}
+	for _, e := range manifest.Entries {
+		if e.Object != entry.Object && len(entries) < buildCacheMaxEntries {
+			entries = append(entries, e)
+		}
+	}
+	manifest.Entries = entries
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(cache.getManifestFile(key), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// Trim evicts the least recently used objects until the cache fits in the size limit.
+// The manifest entries that refer to the evicted objects are also removed.
+func (cache *BuildCache) Trim() error {
+	if cache.MaxSize <= 0 {
+		return nil
+	}
+
+	type object struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var objects []object
+	var total int64
+
+	err := filepath.Walk(filepath.Join(cache.Dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			objects = append(objects, object{path: path, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].modTime.Before(objects[j].modTime)
+	})
+	removed := map[string]bool{}
+	for _, o := range objects {
+		if total <= cache.MaxSize {
+			break
+		}
+		if err := os.Remove(o.path); err != nil {
+			return err
+		}
+		removed[filepath.Base(o.path)] = true
+		total -= o.size
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return cache.removeManifestEntries(removed)
+}
+
+// removeManifestEntries removes the manifest entries that refer to the objects.
+// The manifests without entries are removed.
+func (cache *BuildCache) removeManifestEntries(objects map[string]bool) error {
+	var keys []string
+	err := filepath.Walk(filepath.Join(cache.Dir, "manifests"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// NOTE: The temporary files being written start with ".".
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			keys = append(keys, info.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		manifest := cache.readManifest(key)
+		entries := []buildCacheEntry{}
+		for _, entry := range manifest.Entries {
+			if !objects[entry.Object] {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == len(manifest.Entries) && len(entries) > 0 {
+			continue
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(cache.getManifestFile(key)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		manifest.Entries = entries
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomically(cache.getManifestFile(key), func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
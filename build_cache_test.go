@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildCacheStoreAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "a.cpp")
+	header := filepath.Join(dir, "a.h")
+	output := filepath.Join(dir, "a.o")
+	if err := ioutil.WriteFile(source, []byte("#include \"a.h\""), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(header, []byte("int a;"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(output, []byte("object"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &BuildCache{Dir: filepath.Join(dir, "cache")}
+	command := "clang++ -c a.cpp -o a.o"
+	deps := []string{source, header}
+	if err := cache.Store(command, []string{source}, output, deps); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(output)
+
+	restored, ok := cache.Restore(command, []string{source}, output)
+	if !ok || !reflect.DeepEqual(restored, deps) {
+		t.Fatalf("The cache must be hit")
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil || string(content) != "object" {
+		t.Errorf("Unexpected output: %v", string(content))
+	}
+
+	if _, ok := cache.Restore("clang++ -O2 -c a.cpp -o a.o", []string{source}, output); ok {
+		t.Errorf("The cache must not be hit for a different command")
+	}
+
+	if err := ioutil.WriteFile(header, []byte("int b;"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Restore(command, []string{source}, output); ok {
+		t.Errorf("The cache must not be hit after the header is changed")
+	}
+}
+
+func TestBuildCacheTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "a.cpp")
+	header := filepath.Join(dir, "a.h")
+	output := filepath.Join(dir, "a.o")
+	if err := ioutil.WriteFile(source, []byte("#include \"a.h\""), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(output, []byte("object"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &BuildCache{Dir: filepath.Join(dir, "cache")}
+	debug := "clang++ -c a.cpp -o a.o"
+	release := "clang++ -O2 -c a.cpp -o a.o"
+	deps := []string{source, header}
+	for _, content := range []string{"int a;", "int b;"} {
+		if err := ioutil.WriteFile(header, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		for _, command := range []string{debug, release} {
+			if err := cache.Store(command, []string{source}, output, deps); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// NOTE: The objects of "int a;" are the oldest ones and the release one of "int b;" is the next.
+	debugKey, _ := cache.getKey(debug, []string{source}, output)
+	releaseKey, _ := cache.getKey(release, []string{source}, output)
+	debugEntries := cache.readManifest(debugKey).Entries
+	releaseEntries := cache.readManifest(releaseKey).Entries
+	now := time.Now()
+	for i, object := range []string{debugEntries[1].Object, releaseEntries[1].Object, releaseEntries[0].Object} {
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(cache.getObjectFile(object), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache.MaxSize = int64(len("object"))
+	if err := cache.Trim(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := cache.readManifest(debugKey).Entries; !reflect.DeepEqual(entries, debugEntries[:1]) {
+		t.Errorf("Unexpected entries: %v", entries)
+	}
+	if _, err := os.Stat(cache.getManifestFile(releaseKey)); !os.IsNotExist(err) {
+		t.Errorf("The manifest without entries must be removed: %v", err)
+	}
+	if _, ok := cache.Restore(debug, []string{source}, output); !ok {
+		t.Errorf("The cache must be hit for the newest object")
+	}
+}
+
+func TestBuildCacheOutputDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "a.cpp")
+	if err := ioutil.WriteFile(source, []byte("int a;"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	debug := filepath.Join(dir, "out", "Debug", "obj", "a.cpp.o")
+	release := filepath.Join(dir, "out", "Release", "obj", "a.cpp.o")
+	if err := os.MkdirAll(filepath.Dir(debug), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(debug, []byte("object"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	command := func(output string) string {
+		return "clang++ -MMD -MF " + output + ".d -c " + source + " -o " + output
+	}
+	cache := &BuildCache{Dir: filepath.Join(dir, "cache")}
+	if err := cache.Store(command(debug), []string{source}, debug, []string{source}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Restore(command(release), []string{source}, release); !ok {
+		t.Fatalf("The cache must be hit for another output directory")
+	}
+	content, err := ioutil.ReadFile(release)
+	if err != nil || string(content) != "object" {
+		t.Errorf("Unexpected output: %v", string(content))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func isEmscripten(env *Environment) bool {
+	return getEnvironmentToolchain(env).Flavor == toolchainFlavorEmscripten
+}
+
+// getEmscriptenLinkerFlags converts the settings to the flags of em++.
+func getEmscriptenLinkerFlags(settings *EmscriptenSettings) (result []string) {
+	if len(settings.ExportedFunctions) > 0 {
+		// NOTE: Emscripten exports the C functions with the leading underscore.
+		functions := make([]string, 0, len(settings.ExportedFunctions))
+		for _, f := range settings.ExportedFunctions {
+			if !strings.HasPrefix(f, "_") {
+				f = "_" + f
+			}
+			functions = append(functions, f)
+		}
+		functions = removeDuplicatesFromSlice(functions)
+		result = append(result, quoteShellArg("-sEXPORTED_FUNCTIONS="+strings.Join(functions, ",")))
+	}
+	if settings.InitialMemory > 0 {
+		result = append(result, "-sINITIAL_MEMORY="+strconv.Itoa(settings.InitialMemory))
+	}
+	if settings.AllowMemoryGrowth {
+		result = append(result, "-sALLOW_MEMORY_GROWTH=1")
+	}
+
+	keys := make([]string, 0, len(settings.Settings))
+	for key := range settings.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result = append(result, quoteShellArg("-s"+key+"="+settings.Settings[key]))
+	}
+
+	for _, file := range settings.PreloadFiles {
+		result = append(result, "--preload-file "+quoteShellArg(file))
+	}
+	return result
+}
+
+// getEmscriptenPreloadFiles gets the paths of the files that em++ packages.
+func getEmscriptenPreloadFiles(settings *EmscriptenSettings) (result []string) {
+	for _, file := range settings.PreloadFiles {
+		result = append(result, strings.SplitN(file, "@", 2)[0])
+	}
+	return result
+}
+
+// getEmscriptenOutputs gets the files that em++ writes next to the ".js" file.
+func getEmscriptenOutputs(executableFile string, settings *EmscriptenSettings) []string {
+	base := strings.TrimSuffix(executableFile, ".js")
+	result := []string{base + ".wasm"}
+	if len(settings.PreloadFiles) > 0 {
+		result = append(result, base+".data")
+	}
+	return result
+}
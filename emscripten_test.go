@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmscriptenLinkerFlags(t *testing.T) {
+	settings := normalizeEmscriptenSettings("examples/app", EmscriptenSettings{
+		ExportedFunctions: []string{"main", "_update"},
+		InitialMemory:     16777216,
+		AllowMemoryGrowth: true,
+		PreloadFiles:      []string{"assets", "../data/level.bin@/levels/1.bin"},
+		Settings:          map[string]string{"MODULARIZE": "1", "ASSERTIONS": "2"},
+	})
+
+	expected := []string{
+		"-sEXPORTED_FUNCTIONS=_main,_update",
+		"-sINITIAL_MEMORY=16777216",
+		"-sALLOW_MEMORY_GROWTH=1",
+		"-sASSERTIONS=2",
+		"-sMODULARIZE=1",
+		"--preload-file examples/app/assets@/assets",
+		"--preload-file examples/data/level.bin@/levels/1.bin",
+	}
+	if actual := getEmscriptenLinkerFlags(&settings); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	if actual := getEmscriptenPreloadFiles(&settings); !reflect.DeepEqual(actual, []string{"examples/app/assets", "examples/data/level.bin"}) {
+		t.Errorf("Unexpected preload files: %v", actual)
+	}
+	if actual := getEmscriptenOutputs("out/bin/app.js", &settings); !reflect.DeepEqual(actual, []string{"out/bin/app.wasm", "out/bin/app.data"}) {
+		t.Errorf("Unexpected outputs: %v", actual)
+	}
+}
+
+func TestGetEmscriptenSettings(t *testing.T) {
+	common := &Node{
+		Name: "common",
+		Emscripten: EmscriptenSettings{
+			ExportedFunctions: []string{"main", "update"},
+			PreloadFiles:      []string{"assets@/assets"},
+			Settings:          map[string]string{"ASSERTIONS": "1"},
+		},
+	}
+	node := &Node{
+		Name: "app",
+		Emscripten: EmscriptenSettings{
+			ExportedFunctions: []string{"main", "render"},
+			Settings:          map[string]string{"ASSERTIONS": "2"},
+		},
+		Tagged: map[string]*Node{
+			"wasm": &Node{
+				Emscripten: EmscriptenSettings{
+					ExportedFunctions: []string{"_update"},
+					PreloadFiles:      []string{"assets@/assets", "fonts@/fonts"},
+				},
+			},
+		},
+		Configs: []*Node{common},
+	}
+
+	settings := node.GetEmscriptenSettings(&Environment{Tags: []string{"wasm"}})
+	if !reflect.DeepEqual(settings.ExportedFunctions, []string{"main", "render", "_update", "update"}) {
+		t.Errorf("Unexpected exported functions: %v", settings.ExportedFunctions)
+	}
+	if !reflect.DeepEqual(settings.PreloadFiles, []string{"assets@/assets", "fonts@/fonts"}) {
+		t.Errorf("Unexpected preload files: %v", settings.PreloadFiles)
+	}
+	if !reflect.DeepEqual(settings.Settings, map[string]string{"ASSERTIONS": "2"}) {
+		t.Errorf("Unexpected settings: %v", settings.Settings)
+	}
+	if actual := getEmscriptenLinkerFlags(&settings); actual[0] != "-sEXPORTED_FUNCTIONS=_main,_render,_update" {
+		t.Errorf("Unexpected linker flags: %v", actual)
+	}
+}
@@ -4,7 +4,10 @@
 #include <TargetConditionals.h>
 #endif
 
-#if defined(linux) || defined(__linux) || defined(__linux__)
+#if defined(__EMSCRIPTEN__)
+    // Emscripten (WebAssembly)
+    #define ENGINE_PLATFORM_EMSCRIPTEN
+#elif defined(linux) || defined(__linux) || defined(__linux__)
     // Linux
     #define ENGINE_PLATFORM_LINUX
 #elif defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__)
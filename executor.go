@@ -0,0 +1,784 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NinjaExecutor builds the ninja definitions in-process without ninja.
+type NinjaExecutor struct {
+	Generator *NinjaGenerator
+	LogFile   string
+	Jobs      int
+	Cache     *BuildCache
+
+	rules     map[string]*NinjaRule
+	variables map[string]string
+	producers map[string]*NinjaBuild
+	pools     map[string]int
+	log       map[string]*NinjaLogEntry
+	logWriter *os.File
+	mtimes    map[string]time.Time
+	edges     map[*NinjaBuild]*executorEdge
+}
+
+// NinjaLogEntry represents a record of the build log for an output file.
+type NinjaLogEntry struct {
+	Output      string   `json:"output"`
+	CommandHash string   `json:"command_hash"`
+	Deps        []string `json:"deps,omitempty"`
+}
+
+type executorEdge struct {
+	build      *NinjaBuild
+	rule       *NinjaRule
+	command    string
+	rspFile    string
+	rspContent string
+	deps       []*executorEdge
+	inputDeps  []*executorEdge
+	dependents []*executorEdge
+	pending    int
+	dirty      bool
+}
+
+type executorResult struct {
+	edge   *executorEdge
+	output []byte
+	deps   []string
+	cached bool
+	err    error
+}
+
+func isNinjaVarChar(c byte, braces bool) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '_' || c == '-' || (braces && c == '.')
+}
+
+// expandNinjaVariables evaluates a ninja string such as "$cxx -c $in -o ${out}".
+func expandNinjaVariables(str string, lookup func(name string) string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c != '$' || i+1 >= len(str) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch next := str[i]; {
+		case next == '$' || next == ' ' || next == ':':
+			buf.WriteByte(next)
+		case next == '\n':
+			for i+1 < len(str) && str[i+1] == ' ' {
+				i++
+			}
+		case next == '{':
+			end := strings.IndexByte(str[i:], '}')
+			if end < 0 {
+				buf.WriteString(str[i-1:])
+				return buf.String()
+			}
+			buf.WriteString(lookup(str[i+1 : i+end]))
+			i += end
+		case isNinjaVarChar(next, false):
+			start := i
+			for i+1 < len(str) && isNinjaVarChar(str[i+1], false) {
+				i++
+			}
+			buf.WriteString(lookup(str[start : i+1]))
+		default:
+			buf.WriteByte('$')
+			buf.WriteByte(next)
+		}
+	}
+	return buf.String()
+}
+
+func quoteShellArg(arg string) string {
+	if len(arg) == 0 {
+		return "''"
+	}
+	safe := true
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		if !(('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("_+-./:@%=,", c) >= 0) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+func concatStringSlices(slices ...[]string) (result []string) {
+	for _, s := range slices {
+		result = append(result, s...)
+	}
+	return result
+}
+
+func joinShellArgs(args []string, separator string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteShellArg(arg))
+	}
+	return strings.Join(quoted, separator)
+}
+
+// parseDepFile parses a makefile-style dependency file generated by -MMD.
+func parseDepFile(content string) (deps []string) {
+	content = strings.Replace(content, "\\\r\n", " ", -1)
+	content = strings.Replace(content, "\\\n", " ", -1)
+
+	for _, line := range strings.Split(content, "\n") {
+		var tokens []string
+		var token bytes.Buffer
+		flush := func() {
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		}
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '#'):
+				token.WriteByte(line[i+1])
+				i++
+			case c == '$' && i+1 < len(line) && line[i+1] == '$':
+				token.WriteByte('$')
+				i++
+			case c == ' ' || c == '\t' || c == '\r':
+				flush()
+			default:
+				token.WriteByte(c)
+			}
+		}
+		flush()
+
+		isDep := false
+		for _, t := range tokens {
+			if isDep {
+				deps = append(deps, t)
+			} else if strings.HasSuffix(t, ":") {
+				isDep = true
+			}
+		}
+	}
+	return deps
+}
+
+func (edge *executorEdge) pool() string {
+	if len(edge.build.Pool) > 0 {
+		return edge.build.Pool
+	}
+	return edge.rule.Pool
+}
+
+// commandHash gets the hash of the command. Like ninja, the content of
+// the response file is a part of the command.
+func (edge *executorEdge) commandHash() string {
+	if len(edge.rspFile) > 0 {
+		return hashCommand(edge.command + ";rspfile=" + edge.rspContent)
+	}
+	return hashCommand(edge.command)
+}
+
+func hashCommand(command string) string {
+	h := fnv.New64a()
+	h.Write([]byte(command))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (executor *NinjaExecutor) loadLog() error {
+	executor.log = map[string]*NinjaLogEntry{}
+
+	content, err := ioutil.ReadFile(executor.LogFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		entry := &NinjaLogEntry{}
+		if err := json.Unmarshal([]byte(line), entry); err != nil {
+			// NOTE: Ignore broken records, e.g. written by an interrupted build.
+			continue
+		}
+		executor.log[entry.Output] = entry
+	}
+	return nil
+}
+
+func (executor *NinjaExecutor) writeLog() error {
+	var buf bytes.Buffer
+	for _, entry := range executor.log {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	return ioutil.WriteFile(executor.LogFile, buf.Bytes(), 0644)
+}
+
+func (executor *NinjaExecutor) lookupVariable(e *NinjaBuild, rule *NinjaRule, name string, escape bool) string {
+	join := func(paths []string, separator string) string {
+		if escape {
+			return joinShellArgs(paths, separator)
+		}
+		return strings.Join(paths, separator)
+	}
+	switch name {
+	case "in":
+		return join(e.Inputs, " ")
+	case "in_newline":
+		return join(e.Inputs, "\n")
+	case "out":
+		return join(e.Outputs, " ")
+	}
+	if v, ok := e.Variables[name]; ok {
+		// NOTE: The values of build variables are literal strings.
+		return v
+	}
+	if v := executor.getRuleVariable(e, rule, name); len(v) > 0 {
+		return v
+	}
+	return executor.lookupGlobal(name)
+}
+
+func (executor *NinjaExecutor) lookupGlobal(name string) string {
+	return executor.variables[name]
+}
+
+func (executor *NinjaExecutor) getRuleVariable(e *NinjaBuild, rule *NinjaRule, name string) string {
+	value := ""
+	switch name {
+	case "command":
+		value = rule.Command
+	case "description":
+		value = rule.Description
+	case "depfile":
+		value = rule.DepFile
+	case "deps":
+		value = rule.Deps
+	case "rspfile":
+		value = rule.RspFile
+	case "rspfile_content":
+		value = rule.RspFileContent
+	default:
+		return ""
+	}
+	// NOTE: Like ninja, the paths are shell-escaped only in the command and the response file.
+	escape := name == "command" || name == "rspfile_content"
+	return expandNinjaVariables(value, func(v string) string {
+		if v == name {
+			return ""
+		}
+		return executor.lookupVariable(e, rule, v, escape)
+	})
+}
+
+func (executor *NinjaExecutor) getMTime(path string) (time.Time, bool) {
+	if mtime, ok := executor.mtimes[path]; ok {
+		return mtime, !mtime.IsZero()
+	}
+
+	var mtime time.Time
+	if e, ok := executor.producers[path]; ok && e.Rule == "phony" {
+		// NOTE: The timestamp of a phony target is the newest one of its inputs.
+		for _, input := range concatStringSlices(e.Inputs, e.ImplicitDeps) {
+			if t, ok := executor.getMTime(input); ok && t.After(mtime) {
+				mtime = t
+			}
+		}
+	} else if info, err := os.Stat(path); err == nil {
+		mtime = info.ModTime()
+	}
+	executor.mtimes[path] = mtime
+	return mtime, !mtime.IsZero()
+}
+
+func (executor *NinjaExecutor) getDeps(edge *executorEdge) []string {
+	if len(edge.build.Outputs) == 0 {
+		return nil
+	}
+	if edge.rule.Deps == "gcc" || edge.rule.Deps == "msvc" {
+		if entry, ok := executor.log[edge.build.Outputs[0]]; ok {
+			return entry.Deps
+		}
+		return nil
+	}
+	depFile := executor.getRuleVariable(edge.build, edge.rule, "depfile")
+	if len(depFile) == 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(depFile)
+	if err != nil {
+		return nil
+	}
+	return parseDepFile(string(content))
+}
+
+// isDirty returns true if the edge must run. Like ninja, the order-only dependencies
+// only make the edge wait for them and the edge does not run again when they run.
+func (executor *NinjaExecutor) isDirty(edge *executorEdge) bool {
+	for _, dep := range edge.inputDeps {
+		if dep.dirty {
+			return true
+		}
+	}
+	if edge.rule == nil {
+		// NOTE: A phony target without inputs is dirty only if it does not exist.
+		if len(edge.build.Inputs)+len(edge.build.ImplicitDeps) == 0 {
+			for _, output := range edge.build.Outputs {
+				if _, ok := executor.getMTime(output); !ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var oldest time.Time
+	for _, output := range concatStringSlices(edge.build.Outputs, edge.build.ImplicitOuts) {
+		mtime, ok := executor.getMTime(output)
+		if !ok {
+			return true
+		}
+		if oldest.IsZero() || mtime.Before(oldest) {
+			oldest = mtime
+		}
+		entry, ok := executor.log[output]
+		if !ok || entry.CommandHash != edge.commandHash() {
+			return true
+		}
+	}
+
+	for _, input := range concatStringSlices(edge.build.Inputs, edge.build.ImplicitDeps, executor.getDeps(edge)) {
+		mtime, ok := executor.getMTime(input)
+		if !ok || mtime.After(oldest) {
+			return true
+		}
+	}
+
+	// NOTE: The headers discovered by the depfile are the implicit dependencies, so the edge
+	// runs again if an order-only dependency such as an action regenerates them.
+	for _, input := range executor.getDeps(edge) {
+		if e, ok := executor.producers[input]; ok {
+			if dep, ok := executor.edges[e]; ok && dep.dirty {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// plan collects the edges needed to build the targets in topological order.
+func (executor *NinjaExecutor) plan(targets []string) ([]*executorEdge, error) {
+	edges := map[*NinjaBuild]*executorEdge{}
+	executor.edges = edges
+	visiting := map[*NinjaBuild]bool{}
+	var ordered []*executorEdge
+
+	var visit func(path, neededBy string) (*executorEdge, error)
+	visit = func(path, neededBy string) (*executorEdge, error) {
+		e, ok := executor.producers[path]
+		if !ok {
+			if _, err := os.Stat(path); err != nil {
+				if len(neededBy) > 0 {
+					return nil, errors.Errorf("'%s', needed by '%s', missing and no known rule to make it", path, neededBy)
+				}
+				return nil, errors.Errorf("unknown target '%s'", path)
+			}
+			return nil, nil
+		}
+		if edge, ok := edges[e]; ok {
+			return edge, nil
+		}
+		if visiting[e] {
+			return nil, errors.Errorf("dependency cycle: %s", path)
+		}
+		visiting[e] = true
+
+		edge := &executorEdge{build: e}
+		if e.Rule != "phony" {
+			rule, ok := executor.rules[e.Rule]
+			if !ok {
+				return nil, errors.Errorf("unknown build rule '%s'", e.Rule)
+			}
+			edge.rule = rule
+			edge.command = executor.getRuleVariable(e, rule, "command")
+			edge.rspFile = executor.getRuleVariable(e, rule, "rspfile")
+			if len(edge.rspFile) > 0 {
+				edge.rspContent = executor.getRuleVariable(e, rule, "rspfile_content")
+			}
+		}
+
+		seen := map[*executorEdge]bool{}
+		inputs := len(e.Inputs) + len(e.ImplicitDeps)
+		for i, input := range concatStringSlices(e.Inputs, e.ImplicitDeps, e.OrderOnlyDeps) {
+			dep, err := visit(input, path)
+			if err != nil {
+				return nil, err
+			}
+			if dep == nil {
+				continue
+			}
+			if i < inputs {
+				edge.inputDeps = append(edge.inputDeps, dep)
+			}
+			if !seen[dep] {
+				seen[dep] = true
+				edge.deps = append(edge.deps, dep)
+				dep.dependents = append(dep.dependents, edge)
+			}
+		}
+
+		visiting[e] = false
+		edges[e] = edge
+		ordered = append(ordered, edge)
+		return edge, nil
+	}
+
+	for _, target := range targets {
+		if _, err := visit(target, ""); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+func (executor *NinjaExecutor) run(edge *executorEdge) ([]byte, error) {
+	for _, output := range concatStringSlices(edge.build.Outputs, edge.build.ImplicitOuts) {
+		if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(edge.rspFile) > 0 {
+		if err := os.MkdirAll(filepath.Dir(edge.rspFile), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(edge.rspFile, []byte(edge.rspContent), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", edge.command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", edge.command)
+	}
+	return cmd.CombinedOutput()
+}
+
+func isCacheableEdge(edge *executorEdge) bool {
+	// NOTE: Only compile commands that report their headers can be cached.
+	return (edge.rule.Deps == "gcc" || edge.rule.Deps == "msvc") && len(edge.build.Outputs) == 1 && len(edge.build.ImplicitOuts) == 0
+}
+
+// execute runs the command of the edge or restores its output from the cache.
+// It is called from worker goroutines.
+func (executor *NinjaExecutor) execute(edge *executorEdge) (result executorResult) {
+	result.edge = edge
+
+	cacheable := executor.Cache != nil && isCacheableEdge(edge)
+	if cacheable {
+		if deps, ok := executor.Cache.Restore(edge.command, edge.build.Inputs, edge.build.Outputs[0]); ok {
+			result.deps = deps
+			result.cached = true
+			return result
+		}
+	}
+
+	result.output, result.err = executor.run(edge)
+	if edge.rule.Deps == "msvc" {
+		// NOTE: Like ninja, the headers are removed from the output even if the command fails.
+		result.deps, result.output = parseShowIncludes(result.output)
+	}
+	if result.err != nil {
+		return result
+	}
+
+	if len(edge.rspFile) > 0 {
+		// NOTE: Like ninja, the response file is kept only if the command fails.
+		os.Remove(edge.rspFile)
+	}
+
+	if edge.rule.Deps == "gcc" {
+		depFile := executor.getRuleVariable(edge.build, edge.rule, "depfile")
+		if content, err := ioutil.ReadFile(depFile); err == nil {
+			result.deps = parseDepFile(string(content))
+			// NOTE: The dependencies are stored in the build log like ninja does.
+			os.Remove(depFile)
+		}
+	}
+
+	if cacheable {
+		if err := executor.Cache.Store(edge.command, edge.build.Inputs, edge.build.Outputs[0], result.deps); err != nil {
+			result.output = append(result.output, []byte(fmt.Sprintln("warning: Failed to store the cache:", err))...)
+		}
+	}
+	return result
+}
+
+func (executor *NinjaExecutor) finish(edge *executorEdge, deps []string) error {
+	for _, output := range concatStringSlices(edge.build.Outputs, edge.build.ImplicitOuts) {
+		entry := &NinjaLogEntry{
+			Output:      output,
+			CommandHash: edge.commandHash(),
+			Deps:        deps,
+		}
+		executor.log[output] = entry
+		delete(executor.mtimes, output)
+
+		// NOTE: Append the record immediately so that an interrupted build keeps its progress.
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := executor.logWriter.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (executor *NinjaExecutor) defaultTargets() (result []string) {
+	if len(executor.Generator.Defaults) > 0 {
+		return executor.Generator.Defaults
+	}
+
+	inputs := map[string]bool{}
+	for _, e := range executor.Generator.Nodes {
+		for _, input := range concatStringSlices(e.Inputs, e.ImplicitDeps, e.OrderOnlyDeps) {
+			inputs[input] = true
+		}
+	}
+	for _, e := range executor.Generator.Nodes {
+		for _, output := range e.Outputs {
+			if !inputs[output] {
+				result = append(result, output)
+			}
+		}
+	}
+	return result
+}
+
+func (executor *NinjaExecutor) init() error {
+	executor.rules = map[string]*NinjaRule{}
+	for _, rule := range executor.Generator.Rules {
+		executor.rules[rule.Name] = rule
+	}
+
+	executor.variables = map[string]string{}
+	for _, v := range executor.Generator.Variables {
+		kv := strings.SplitN(v, " = ", 2)
+		if len(kv) == 2 {
+			executor.variables[kv[0]] = expandNinjaVariables(kv[1], executor.lookupGlobal)
+		}
+	}
+
+	executor.pools = map[string]int{consolePoolName: 1}
+	for _, pool := range executor.Generator.Pools {
+		executor.pools[pool.Name] = pool.Depth
+	}
+
+	executor.producers = map[string]*NinjaBuild{}
+	for _, e := range executor.Generator.Nodes {
+		for _, output := range concatStringSlices(e.Outputs, e.ImplicitOuts) {
+			if _, ok := executor.producers[output]; ok {
+				return errors.Errorf("multiple rules generate %s", output)
+			}
+			executor.producers[output] = e
+		}
+	}
+
+	executor.mtimes = map[string]time.Time{}
+
+	if err := os.MkdirAll(filepath.Dir(executor.LogFile), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "Failed to create output directory \"%s\"", filepath.Dir(executor.LogFile))
+	}
+	if err := executor.loadLog(); err != nil {
+		return err
+	}
+
+	// NOTE: Compact the build log before appending new records.
+	if err := executor.writeLog(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(executor.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	executor.logWriter = file
+	return nil
+}
+
+// Build builds the targets. If targets are empty, all outputs that are not used as inputs are built.
+func (executor *NinjaExecutor) Build(targets []string) error {
+	if err := executor.init(); err != nil {
+		return err
+	}
+	defer executor.logWriter.Close()
+
+	if len(targets) == 0 {
+		targets = executor.defaultTargets()
+	}
+
+	edges, err := executor.plan(targets)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, edge := range edges {
+		edge.dirty = executor.isDirty(edge)
+		if edge.dirty && edge.rule != nil {
+			total++
+		}
+		edge.pending = len(edge.deps)
+	}
+	if total == 0 {
+		fmt.Println("baselard: no work to do.")
+		return nil
+	}
+
+	jobs := executor.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU() + 2
+	}
+
+	var ready []*executorEdge
+	for _, edge := range edges {
+		if edge.pending == 0 {
+			ready = append(ready, edge)
+		}
+	}
+
+	results := make(chan executorResult)
+	running := 0
+	finished := 0
+	remaining := len(edges)
+	cached := 0
+	poolUsage := map[string]int{}
+	var buildErr error
+
+	complete := func(edge *executorEdge) {
+		remaining--
+		for _, d := range edge.dependents {
+			d.pending--
+			if d.pending == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	for remaining > 0 {
+		for buildErr == nil && running < jobs {
+			// NOTE: Skip the edges whose pools are full.
+			index := 0
+			for ; index < len(ready); index++ {
+				e := ready[index]
+				if !e.dirty || e.rule == nil {
+					break
+				}
+				if depth, ok := executor.pools[e.pool()]; !ok || poolUsage[e.pool()] < depth {
+					break
+				}
+			}
+			if index >= len(ready) {
+				break
+			}
+			edge := ready[index]
+			ready = append(ready[:index], ready[index+1:]...)
+			if !edge.dirty || edge.rule == nil {
+				complete(edge)
+				continue
+			}
+			poolUsage[edge.pool()]++
+
+			finished++
+			description := executor.getRuleVariable(edge.build, edge.rule, "description")
+			if len(description) == 0 {
+				description = edge.command
+			}
+			fmt.Fprintf(writer, "[%d/%d] %s\n", finished, total, description)
+			writer.Flush()
+
+			running++
+			go func(edge *executorEdge) {
+				results <- executor.execute(edge)
+			}(edge)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		poolUsage[result.edge.pool()]--
+		if result.err != nil {
+			fmt.Fprintf(writer, "FAILED: %s\n%s\n", strings.Join(result.edge.build.Outputs, " "), result.edge.command)
+			writer.Write(result.output)
+			writer.Flush()
+			if buildErr == nil {
+				buildErr = errors.New("subcommand failed")
+			}
+			continue
+		}
+		if result.cached {
+			cached++
+		}
+		writer.Write(result.output)
+		if err := executor.finish(result.edge, result.deps); err != nil && buildErr == nil {
+			buildErr = err
+		}
+		complete(result.edge)
+	}
+
+	if buildErr == nil && remaining > 0 {
+		// NOTE: The ready edges are stuck in the pools that cannot run them, e.g. of depth 0.
+		pools := []string{}
+		for _, edge := range ready {
+			pools = append(pools, fmt.Sprintf("'%s'", edge.pool()))
+		}
+		buildErr = errors.Errorf("%d build statements cannot be scheduled, waiting for pool %s",
+			remaining, strings.Join(removeDuplicatesFromSlice(pools), ", "))
+	}
+
+	if executor.Cache != nil {
+		if cached > 0 {
+			fmt.Fprintf(writer, "baselard: %d of %d commands restored from the cache.\n", cached, finished)
+		}
+		if err := executor.Cache.Trim(); err != nil && buildErr == nil {
+			buildErr = err
+		}
+	}
+	return buildErr
+}
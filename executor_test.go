@@ -0,0 +1,273 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpandNinjaVariables(t *testing.T) {
+	variables := map[string]string{
+		"cxx":    "clang++",
+		"out":    "a.o",
+		"cflags": "-Wall",
+	}
+	lookup := func(name string) string {
+		return variables[name]
+	}
+
+	actual := expandNinjaVariables("$cxx $cflags -c a$ b.cpp -o ${out} $$HOME $:", lookup)
+	expected := "clang++ -Wall -c a b.cpp -o a.o $HOME :"
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
+func TestParseDepFile(t *testing.T) {
+	content := "out/a.o: a.cpp include/a.h \\\n  include/b\\ c.h \\\r\n  include/d.h\n"
+	actual := parseDepFile(content)
+	expected := []string{"a.cpp", "include/a.h", "include/b c.h", "include/d.h"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected deps:\n%v", actual)
+	}
+}
+
+func TestQuoteShellArg(t *testing.T) {
+	cases := map[string]string{
+		"out/a.o":     "out/a.o",
+		"my dir/a.o":  "'my dir/a.o'",
+		"it's":        `'it'\''s'`,
+		"-DVERSION=1": "-DVERSION=1",
+	}
+	for arg, expected := range cases {
+		if actual := quoteShellArg(arg); actual != expected {
+			t.Errorf("Unexpected string: %v", actual)
+		}
+	}
+}
+
+func TestNinjaExecutorBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "a.txt")
+	output := filepath.Join(dir, "out", "b.txt")
+	if err := ioutil.WriteFile(input, []byte("hello"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	generator := &NinjaGenerator{}
+	generator.AddRule(&NinjaRule{
+		Name:    "copy",
+		Command: "cp $in $out",
+	})
+	generator.AddNode(&NinjaBuild{
+		Rule:    "copy",
+		Inputs:  []string{input},
+		Outputs: []string{output},
+	})
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(dir, "out", ".baselard_log"),
+	}
+	if err := executor.Build(nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil || string(content) != "hello" {
+		t.Errorf("Unexpected output: %v", string(content))
+	}
+
+	edges, err := executor.plan([]string{output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != 1 || executor.isDirty(edges[0]) {
+		t.Errorf("The output must be up to date")
+	}
+}
+
+func TestNinjaExecutorBuildRspFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b c.txt")}
+	output := filepath.Join(dir, "out", "ab.txt")
+	for i, input := range inputs {
+		if err := ioutil.WriteFile(input, []byte{byte('a' + i)}, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	generator := &NinjaGenerator{}
+	generator.AddRule(&NinjaRule{
+		Name:           "concat",
+		Command:        "xargs cat < $out.rsp > $out",
+		RspFile:        "$out.rsp",
+		RspFileContent: "$in",
+	})
+	generator.AddNode(&NinjaBuild{
+		Rule:    "concat",
+		Inputs:  inputs,
+		Outputs: []string{output},
+	})
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(dir, "out", ".baselard_log"),
+	}
+	if err := executor.Build(nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil || string(content) != "ab" {
+		t.Errorf("Unexpected output: %v", string(content))
+	}
+	if _, err := os.Stat(output + ".rsp"); !os.IsNotExist(err) {
+		t.Errorf("The response file must be removed")
+	}
+}
+
+func TestNinjaExecutorBuildPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// NOTE: The command fails if another command in the same pool is running.
+	lock := filepath.Join(dir, "lock")
+	generator := &NinjaGenerator{}
+	generator.AddPool(&NinjaPool{Name: "heavy", Depth: 1})
+	generator.AddRule(&NinjaRule{
+		Name:    "touch",
+		Command: "mkdir " + lock + " && sleep 0.1 && rmdir " + lock + " && touch $out",
+		Pool:    "heavy",
+	})
+	for _, name := range []string{"a", "b", "c"} {
+		generator.AddNode(&NinjaBuild{
+			Rule:    "touch",
+			Outputs: []string{filepath.Join(dir, "out", name)},
+		})
+	}
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(dir, "out", ".baselard_log"),
+		Jobs:      3,
+	}
+	if err := executor.Build(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNinjaExecutorBuildStuckPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	generator := &NinjaGenerator{}
+	generator.AddPool(&NinjaPool{Name: "closed", Depth: 0})
+	generator.AddRule(&NinjaRule{Name: "touch", Command: "touch $out"})
+	generator.AddNode(&NinjaBuild{
+		Rule:    "touch",
+		Outputs: []string{filepath.Join(dir, "out", "a")},
+		Pool:    "closed",
+	})
+	generator.AddNode(&NinjaBuild{
+		Rule:    "touch",
+		Outputs: []string{filepath.Join(dir, "out", "b")},
+		Inputs:  []string{filepath.Join(dir, "out", "a")},
+	})
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(dir, "out", ".baselard_log"),
+		Jobs:      2,
+	}
+	err = executor.Build(nil)
+	if err == nil || err.Error() != "2 build statements cannot be scheduled, waiting for pool 'closed'" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestNinjaExecutorBuildOrderOnlyDeps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "main.c")
+	actionInput := filepath.Join(dir, "config.in")
+	header := filepath.Join(dir, "out", "config.h")
+	object := filepath.Join(dir, "out", "main.o")
+	for _, file := range []string{source, actionInput} {
+		if err := ioutil.WriteFile(file, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	generator := &NinjaGenerator{}
+	generator.AddRule(&NinjaRule{
+		Name:    "copy",
+		Command: "cp $in $out",
+	})
+	generator.AddNode(&NinjaBuild{
+		Rule:    "copy",
+		Inputs:  []string{actionInput},
+		Outputs: []string{header},
+	})
+	generator.AddNode(&NinjaBuild{
+		Rule:          "copy",
+		Inputs:        []string{source},
+		OrderOnlyDeps: []string{header},
+		Outputs:       []string{object},
+	})
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(dir, "out", ".baselard_log"),
+	}
+	if err := executor.Build([]string{object}); err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: The action runs again but the compile edge must stay clean.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(actionInput, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := executor.init(); err != nil {
+		t.Fatal(err)
+	}
+	edges, err := executor.plan([]string{object})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != 2 {
+		t.Fatalf("Unexpected edges: %v", edges)
+	}
+	for _, edge := range edges {
+		edge.dirty = executor.isDirty(edge)
+	}
+	executor.logWriter.Close()
+	if !edges[0].dirty || edges[0].build.Outputs[0] != header {
+		t.Errorf("The action must be dirty")
+	}
+	if edges[1].dirty {
+		t.Errorf("The compile edge must not be dirty because of the order-only dependency")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// externalFlags represents the flags that the targets depending on an external library use.
+type externalFlags struct {
+	CompilerFlags []string
+	LinkerFlags   []string
+}
+
+// externalFlagsCache caches the resolved flags so that pkg-config runs once for each package.
+var externalFlagsCache = map[string]*externalFlags{}
+
+// getExternalPackages gets the pkg-config packages of the node. The name of the target
+// is used unless the packages are specified.
+func getExternalPackages(settings *ExternalSettings, node *Node) []string {
+	if len(settings.Packages) > 0 {
+		return settings.Packages
+	}
+	return []string{node.Name}
+}
+
+// resolveExternal resolves the flags of the external library at generation time.
+func resolveExternal(env *Environment, node *Node) (*externalFlags, error) {
+	settings := node.GetExternalSettings(env)
+	packages := getExternalPackages(&settings, node)
+	sysroot := ""
+	if env.Platform != nil {
+		sysroot = env.Platform.Sysroot
+	}
+
+	key := fmt.Sprintln(packages, settings.Paths, settings.Static, sysroot)
+	if flags, ok := externalFlagsCache[key]; ok {
+		return flags, nil
+	}
+
+	var flags *externalFlags
+	var err error
+	if len(settings.Paths) > 0 {
+		flags, err = readPkgConfigFiles(settings.Paths, packages, settings.Static, sysroot)
+	} else {
+		flags, err = runPkgConfig(packages, settings.Static, sysroot)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve external target \"%s\"", node.Name)
+	}
+	externalFlagsCache[key] = flags
+	return flags, nil
+}
+
+// getPkgConfigCommand gets the pkg-config executable. PKG_CONFIG overrides it
+// in the same way as autoconf and meson.
+func getPkgConfigCommand() string {
+	if command := os.Getenv("PKG_CONFIG"); len(command) > 0 {
+		return command
+	}
+	return "pkg-config"
+}
+
+func runPkgConfig(packages []string, static bool, sysroot string) (*externalFlags, error) {
+	run := func(option string) ([]string, error) {
+		args := []string{option}
+		if static {
+			args = append(args, "--static")
+		}
+		cmd := exec.Command(getPkgConfigCommand(), append(args, packages...)...)
+		cmd.Env = os.Environ()
+		if len(sysroot) > 0 {
+			cmd.Env = append(cmd.Env, "PKG_CONFIG_SYSROOT_DIR="+sysroot)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %s", cmd.Args[0], strings.TrimSpace(stderr.String()))
+		}
+		return splitPkgConfigArgs(string(output)), nil
+	}
+
+	cflags, err := run("--cflags")
+	if err != nil {
+		return nil, err
+	}
+	libs, err := run("--libs")
+	if err != nil {
+		return nil, err
+	}
+	return &externalFlags{CompilerFlags: cflags, LinkerFlags: libs}, nil
+}
+
+// pkgConfigFile represents the variables and the fields of a .pc file.
+type pkgConfigFile struct {
+	Variables map[string]string
+	Fields    map[string]string
+}
+
+// expand expands the variables referred to as ${name}. Unlike a shell, $name is kept.
+func (file *pkgConfigFile) expand(value string) string {
+	var buf bytes.Buffer
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(value[i:], '}')
+		if j < 0 {
+			break
+		}
+		buf.WriteString(value[:i])
+		buf.WriteString(file.Variables[value[i+2:i+j]])
+		value = value[i+j+1:]
+	}
+	buf.WriteString(value)
+	return buf.String()
+}
+
+// parsePkgConfigFile parses the .pc file. The variables are expanded
+// in order and ${pcfiledir} refers to the directory of the file.
+func parsePkgConfigFile(path, sysroot string) (*pkgConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file := &pkgConfigFile{
+		Variables: map[string]string{
+			"pcfiledir":     filepath.ToSlash(filepath.Dir(path)),
+			"pc_sysrootdir": sysroot,
+		},
+		Fields: map[string]string{},
+	}
+	if len(sysroot) == 0 {
+		file.Variables["pc_sysrootdir"] = "/"
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		i := strings.IndexAny(line, ":=")
+		if i <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		value := file.expand(strings.TrimSpace(line[i+1:]))
+		if line[i] == '=' {
+			file.Variables[name] = value
+		} else {
+			file.Fields[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read \"%s\"", path)
+	}
+	return file, nil
+}
+
+// splitPkgConfigArgs splits the flags in the .pc file like a shell.
+func splitPkgConfigArgs(str string) (result []string) {
+	var buf bytes.Buffer
+	inArg := false
+	var quote byte
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+			inArg = true
+		case quote != '\'' && c == '\\' && i+1 < len(str):
+			i++
+			buf.WriteByte(str[i])
+			inArg = true
+		case quote == 0 && strings.IndexByte(" \t\r\n", c) >= 0:
+			if inArg {
+				result = append(result, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+		default:
+			buf.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		result = append(result, buf.String())
+	}
+	return result
+}
+
+// parsePkgConfigRequires gets the names of the packages in "Requires" without the versions.
+func parsePkgConfigRequires(str string) (result []string) {
+	fields := strings.Fields(strings.Replace(str, ",", " ", -1))
+	for i := 0; i < len(fields); i++ {
+		if strings.ContainsAny(fields[i], "<>=!") {
+			// NOTE: Skip the version that follows the operator.
+			i++
+			continue
+		}
+		result = append(result, fields[i])
+	}
+	return result
+}
+
+func findPkgConfigFile(paths []string, name string) (string, error) {
+	for _, dir := range paths {
+		path := filepath.Join(dir, name+".pc")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("Package \"%s\" is not found in %s", name, strings.Join(paths, ", "))
+}
+
+// prefixPkgConfigSysroot prefixes the include and library directories with the sysroot
+// like PKG_CONFIG_SYSROOT_DIR.
+func prefixPkgConfigSysroot(flags []string, sysroot string) (result []string) {
+	for _, flag := range flags {
+		isDir := strings.HasPrefix(flag, "-I/") || strings.HasPrefix(flag, "-L/")
+		if len(sysroot) > 0 && isDir && !strings.HasPrefix(flag[2:], filepath.ToSlash(sysroot)) {
+			flag = flag[:2] + filepath.ToSlash(filepath.Join(sysroot, flag[2:]))
+		}
+		result = append(result, flag)
+	}
+	return result
+}
+
+// readPkgConfigFiles resolves the flags from the .pc files in the paths without pkg-config.
+// The libraries of the required packages follow the ones that require them.
+func readPkgConfigFiles(paths, packages []string, static bool, sysroot string) (*externalFlags, error) {
+	files := map[string]*pkgConfigFile{}
+	cflags := []string{}
+
+	var load func(name string) error
+	load = func(name string) error {
+		if _, ok := files[name]; ok {
+			return nil
+		}
+		path, err := findPkgConfigFile(paths, name)
+		if err != nil {
+			return err
+		}
+		file, err := parsePkgConfigFile(path, sysroot)
+		if err != nil {
+			return err
+		}
+		files[name] = file
+		cflags = append(cflags, splitPkgConfigArgs(file.Fields["Cflags"])...)
+
+		// NOTE: The private requirements provide the flags to compile but the libraries only for static linking.
+		requires := parsePkgConfigRequires(file.Fields["Requires"] + " " + file.Fields["Requires.private"])
+		for _, require := range requires {
+			if err := load(require); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range packages {
+		if err := load(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// NOTE: The reverse postorder of the packages is a topological order. The requirements
+	// are visited backwards so that the ones listed first come first.
+	order := []string{}
+	visited := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		file := files[name]
+		requires := parsePkgConfigRequires(file.Fields["Requires"])
+		if static {
+			requires = append(requires, parsePkgConfigRequires(file.Fields["Requires.private"])...)
+		}
+		for i := len(requires) - 1; i >= 0; i-- {
+			visit(requires[i])
+		}
+		order = append(order, name)
+	}
+	for i := len(packages) - 1; i >= 0; i-- {
+		visit(packages[i])
+	}
+
+	libs := []string{}
+	for i := len(order) - 1; i >= 0; i-- {
+		file := files[order[i]]
+		libs = append(libs, splitPkgConfigArgs(file.Fields["Libs"])...)
+		if static {
+			libs = append(libs, splitPkgConfigArgs(file.Fields["Libs.private"])...)
+		}
+	}
+
+	return &externalFlags{
+		CompilerFlags: prefixPkgConfigSysroot(removeDuplicateCompilerFlags(cflags), sysroot),
+		LinkerFlags:   prefixPkgConfigSysroot(removeDuplicateLinkerFlags(libs), sysroot),
+	}, nil
+}
+
+// removeDuplicateCompilerFlags removes the duplicate -I and -D flags. The other flags
+// are kept as is because they may take the next argument such as "-include a.h".
+func removeDuplicateCompilerFlags(flags []string) (result []string) {
+	encountered := map[string]bool{}
+	for _, flag := range flags {
+		if len(flag) > 2 && (strings.HasPrefix(flag, "-I") || strings.HasPrefix(flag, "-D")) {
+			if encountered[flag] {
+				continue
+			}
+			encountered[flag] = true
+		}
+		result = append(result, flag)
+	}
+	return result
+}
+
+// removeDuplicateLinkerFlags removes the duplicate -l flags and -L flags. The last -l flags
+// are kept so that the libraries still follow the ones using them. The other flags are kept as is.
+func removeDuplicateLinkerFlags(flags []string) (result []string) {
+	isLibrary := func(flag string) bool {
+		return len(flag) > 2 && strings.HasPrefix(flag, "-l")
+	}
+	last := map[string]int{}
+	for i, flag := range flags {
+		if isLibrary(flag) {
+			last[flag] = i
+		}
+	}
+	encountered := map[string]bool{}
+	for i, flag := range flags {
+		switch {
+		case isLibrary(flag) && last[flag] != i:
+			continue
+		case len(flag) > 2 && strings.HasPrefix(flag, "-L"):
+			if encountered[flag] {
+				continue
+			}
+			encountered[flag] = true
+		}
+		result = append(result, flag)
+	}
+	return result
+}
+
+// getExternalDeps gets the external libraries that the node depends on directly or indirectly.
+func getExternalDeps(node *Node) []*Node {
+	return getTransitiveDeps(node, OutputTypeExternal)
+}
+
+// getExternalFlags gets the flags of the external libraries that the node depends on.
+func getExternalFlags(env *Environment, node *Node) *externalFlags {
+	result := &externalFlags{}
+	for _, dep := range getExternalDeps(node) {
+		flags, err := resolveExternal(env, dep)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		result.CompilerFlags = append(result.CompilerFlags, flags.CompilerFlags...)
+		result.LinkerFlags = append(result.LinkerFlags, flags.LinkerFlags...)
+	}
+	result.CompilerFlags = removeDuplicateCompilerFlags(result.CompilerFlags)
+	result.LinkerFlags = removeDuplicateLinkerFlags(result.LinkerFlags)
+	return result
+}
+
+// getExternalCompilerFlags gets the flags to compile the sources that use the external
+// libraries. The flags are quoted for the toolchain of the environment.
+func getExternalCompilerFlags(env *Environment, node *Node) (result []string) {
+	flags := getExternalFlags(env, node).CompilerFlags
+	if isMSVC(env) {
+		for _, flag := range translateMSVCCompilerFlags(flags) {
+			result = append(result, quoteWindowsArg(flag))
+		}
+		return result
+	}
+	for _, flag := range flags {
+		result = append(result, quoteShellArg(flag))
+	}
+	return result
+}
+
+// getExternalLinkerFlags gets the flags to link the external libraries.
+// Static libraries cannot carry them, so the executable links them instead.
+func getExternalLinkerFlags(env *Environment, node *Node) (result []string) {
+	flags := getExternalFlags(env, node).LinkerFlags
+	if isMSVC(env) {
+		return translateMSVCLinkerFlags(flags)
+	}
+	for _, flag := range flags {
+		result = append(result, quoteShellArg(flag))
+	}
+	return result
+}
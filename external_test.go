@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadPkgConfigFiles(t *testing.T) {
+	paths := []string{"testdata/pkgconfig"}
+
+	flags, err := readPkgConfigFiles(paths, []string{"sdk"}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &externalFlags{
+		CompilerFlags: []string{
+			"-Itestdata/pkgconfig/sdk/include",
+			`-DSDK_NAME="vendor sdk"`,
+			"-I/opt/libpng/include/libpng16",
+			"-I/usr/include",
+		},
+		LinkerFlags: []string{
+			"-Ltestdata/pkgconfig/sdk/lib", "-lsdk", "-Wl,-rpath,$ORIGIN",
+			"-L/opt/libpng/lib", "-lpng16",
+			"-L/usr/lib", "-lz",
+		},
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected %v, got %v", expected, flags)
+	}
+
+	flags, err = readPkgConfigFiles(paths, []string{"libpng"}, true, "/sysroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLibs := []string{"-L/sysroot/opt/libpng/lib", "-lpng16", "-lm", "-L/sysroot/usr/lib", "-lz"}
+	if !reflect.DeepEqual(flags.LinkerFlags, expectedLibs) {
+		t.Errorf("Expected %v, got %v", expectedLibs, flags.LinkerFlags)
+	}
+
+	if _, err := readPkgConfigFiles(paths, []string{"unknown"}, false, ""); err == nil {
+		t.Error("Expected an error for the unknown package")
+	}
+}
+
+func TestReadPkgConfigFilesWithArguments(t *testing.T) {
+	flags, err := readPkgConfigFiles([]string{"testdata/pkgconfig"}, []string{"keychain"}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &externalFlags{
+		CompilerFlags: []string{
+			"-DSECURITY", "-include", "b.h",
+			"-include", "a.h", "-include", "b.h",
+			"-I/usr/include",
+		},
+		LinkerFlags: []string{
+			"-lkeychain", "-framework", "Security",
+			"-framework", "CoreFoundation", "-framework", "Security",
+			"-L/usr/lib", "-lz",
+		},
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected %v, got %v", expected, flags)
+	}
+}
+
+func TestParsePkgConfigRequires(t *testing.T) {
+	actual := parsePkgConfigRequires("libpng >= 1.6, zlib,glib-2.0 != 2.1 gio-2.0")
+	expected := []string{"libpng", "zlib", "glib-2.0", "gio-2.0"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestGetExternalDeps(t *testing.T) {
+	zlib := &Node{Name: "zlib", Type: OutputTypeExternal}
+	png := &Node{Name: "png", Type: OutputTypeStaticLibrary, Dependencies: []*Node{zlib}}
+	app := &Node{Name: "app", Type: OutputTypeExecutable, Dependencies: []*Node{png, zlib}}
+
+	if actual := getExternalDeps(app); !reflect.DeepEqual(actual, []*Node{zlib}) {
+		t.Errorf("Unexpected deps: %v", actual)
+	}
+}
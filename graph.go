@@ -5,15 +5,31 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
 )
 
 // Graph represents a dependency graph.
 type Graph struct {
-	Nodes   []*Node
-	Sources []*Node
+	Nodes          []*Node
+	Sources        []*Node
+	FileTypes      SourceFileTypes
+	ManifestFiles  []string
+	OutDir         string
+	InstallPrefix  string
+	Configurations []Configuration
+	Platforms      []Platform
+	Toolchains     []Toolchain
+	Pools          []Pool
+
+	// HostPlatform is the platform that the host targets run on.
+	HostPlatform string
+
+	// CompilerLauncher is the launcher for the toolchains that do not specify it.
+	CompilerLauncher string
 }
 
 func normalizePathList(base string, paths []string) (result []string) {
@@ -23,6 +39,63 @@ func normalizePathList(base string, paths []string) (result []string) {
 	return result
 }
 
+func normalizeSourceSettings(base string, settings []SourceSettings) (result []SourceSettings) {
+	for _, s := range settings {
+		result = append(result, SourceSettings{
+			Glob:          filepath.Clean(filepath.Join(base, s.Glob)),
+			IncludeDirs:   normalizePathList(base, s.IncludeDirs),
+			Defines:       s.Defines,
+			CompilerFlags: s.CompilerFlags,
+		})
+	}
+	return result
+}
+
+// normalizeEmscriptenSettings normalizes the preload files such as "assets@/assets".
+// The files are mounted at their base names unless the virtual paths are specified.
+func normalizeEmscriptenSettings(base string, settings EmscriptenSettings) EmscriptenSettings {
+	result := settings
+	result.PreloadFiles = nil
+	for _, file := range settings.PreloadFiles {
+		s := strings.SplitN(file, "@", 2)
+		mountPath := "/" + filepath.ToSlash(filepath.Base(s[0]))
+		if len(s) == 2 {
+			mountPath = s[1]
+		}
+		result.PreloadFiles = append(result.PreloadFiles, filepath.Clean(filepath.Join(base, s[0]))+"@"+mountPath)
+	}
+	return result
+}
+
+func normalizeInstallSettings(base string, settings InstallSettings) InstallSettings {
+	result := settings
+	result.Headers = normalizePathList(base, settings.Headers)
+	return result
+}
+
+func normalizeExternalSettings(base string, settings ExternalSettings) ExternalSettings {
+	result := settings
+	result.Paths = normalizePathList(base, settings.Paths)
+	return result
+}
+
+func normalizeActions(base string, actions []Action) (result []Action) {
+	for _, a := range actions {
+		action := Action{
+			Name:        a.Name,
+			Command:     a.Command,
+			Description: a.Description,
+			Inputs:      normalizePathList(base, a.Inputs),
+			Outputs:     normalizePathList(base, a.Outputs),
+		}
+		if len(a.DepFile) > 0 {
+			action.DepFile = filepath.Clean(filepath.Join(base, a.DepFile))
+		}
+		result = append(result, action)
+	}
+	return result
+}
+
 func normalizeConfigFile(filename string) (string, error) {
 	if !filepath.IsAbs(filename) {
 		abs, err := filepath.Abs(filename)
@@ -45,6 +118,38 @@ func splitManifestTarget(str string) (manifestFile, targetName string) {
 	return manifestFile, targetName
 }
 
+func hasConfiguration(configurations []Configuration, config Configuration) bool {
+	for _, c := range configurations {
+		if c.Name == config.Name && c.Platform == config.Platform {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToolchain(toolchains []Toolchain, name string) bool {
+	for _, t := range toolchains {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownPool returns true if the pool is defined in the manifests or built in.
+func isKnownPool(graph *Graph, name string) bool {
+	return name == consolePoolName || name == linkPoolName || hasPool(graph.Pools, name)
+}
+
+func hasPool(pools []Pool, name string) bool {
+	for _, p := range pools {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func parseGraph(manifestFile string) (*Graph, error) {
 	if len(manifestFile) == 0 {
 		log.Fatalln("error: Please specify a manifest file.")
@@ -54,6 +159,16 @@ func parseGraph(manifestFile string) (*Graph, error) {
 	targetNames := []string{}
 	nodes := map[string]*Node{}
 	targets := map[string]Target{}
+	fileTypes := SourceFileTypes{}
+	manifestFileList := []string{}
+	outDir := ""
+	installPrefix := ""
+	compilerLauncher := ""
+	hostPlatform := ""
+	configurations := []Configuration{}
+	platforms := []Platform{}
+	toolchains := []Toolchain{}
+	pools := []Pool{}
 
 	manifestFiles := []string{manifestFile}
 	for len(manifestFiles) > 0 {
@@ -78,6 +193,69 @@ func parseGraph(manifestFile string) (*Graph, error) {
 			return nil, err
 		}
 
+		// NOTE: The root manifest takes precedence over the required manifests.
+		if len(outDir) == 0 {
+			outDir = manifest.OutDir
+		}
+		if len(installPrefix) == 0 {
+			installPrefix = manifest.InstallPrefix
+		}
+		if len(compilerLauncher) == 0 {
+			compilerLauncher = manifest.CompilerLauncher
+		}
+		if len(hostPlatform) == 0 {
+			hostPlatform = manifest.HostPlatform
+		}
+
+		for _, config := range manifest.Configurations {
+			if len(config.Name) == 0 {
+				return nil, errors.Errorf("A configuration has no name in %s", manifestFile)
+			}
+			if !hasConfiguration(configurations, config) {
+				configurations = append(configurations, config)
+			}
+		}
+
+		for _, platform := range manifest.Platforms {
+			if len(platform.Name) == 0 {
+				return nil, errors.Errorf("A platform has no name in %s", manifestFile)
+			}
+			if !hasPlatform(platforms, platform.Name) {
+				platforms = append(platforms, platform)
+			}
+		}
+
+		for _, toolchain := range manifest.Toolchains {
+			if len(toolchain.Name) == 0 {
+				return nil, errors.Errorf("A toolchain has no name in %s", manifestFile)
+			}
+			if !hasToolchain(toolchains, toolchain.Name) {
+				toolchains = append(toolchains, toolchain)
+			}
+		}
+
+		for _, pool := range manifest.Pools {
+			if len(pool.Name) == 0 || pool.Depth <= 0 {
+				return nil, errors.Errorf("A pool must have a name and a positive depth in %s", manifestFile)
+			}
+			if !hasPool(pools, pool.Name) {
+				pools = append(pools, pool)
+			}
+		}
+
+		for ext, name := range manifest.FileTypes {
+			fileType, ok := parseSourceFileType(name)
+			if !ok {
+				return nil, errors.Errorf("Unknown file type \"%s\" for \"%s\" in %s", name, ext, manifestFile)
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if _, ok := fileTypes[ext]; !ok {
+				fileTypes[ext] = fileType
+			}
+		}
+
 		baseDir := filepath.Dir(manifestFile)
 		requiredManifests := []string{}
 
@@ -88,6 +266,14 @@ func parseGraph(manifestFile string) (*Graph, error) {
 					return OutputTypeExecutable
 				case "static_library":
 					return OutputTypeStaticLibrary
+				case "action":
+					return OutputTypeAction
+				case "test":
+					return OutputTypeTest
+				case "external":
+					return OutputTypeExternal
+				case "prebuilt_library":
+					return OutputTypePrebuiltLibrary
 				}
 				if len(target.Type) > 0 {
 					fmt.Println("warning: Unknown type", target.Type)
@@ -109,36 +295,86 @@ func parseGraph(manifestFile string) (*Graph, error) {
 			}
 
 			node := &Node{
-				Name:            target.Name,
-				Type:            outputType,
-				Headers:         normalizePathList(baseDir, target.Headers),
-				Sources:         normalizePathList(baseDir, target.Sources),
-				IncludeDirs:     normalizePathList(baseDir, target.IncludeDirs),
-				LibDirs:         normalizePathList(baseDir, target.LibDirs),
-				Defines:         target.Defines,
-				CompilerFlags:   target.CompilerFlags,
-				CompilerFlagsC:  target.CompilerFlagsC,
-				CompilerFlagsCC: target.CompilerFlagsCC,
-				LinkerFlags:     target.LinkerFlags,
-				MSBuildSettings: target.MSBuildSettings,
-				MSBuildProject:  target.MSBuildProject,
-				Templates:       target.Templates,
+				Name:               target.Name,
+				Type:               outputType,
+				Headers:            normalizePathList(baseDir, target.Headers),
+				Sources:            normalizePathList(baseDir, target.Sources),
+				IncludeDirs:        normalizePathList(baseDir, target.IncludeDirs),
+				LibDirs:            normalizePathList(baseDir, target.LibDirs),
+				Libraries:          normalizePathList(baseDir, target.Libraries),
+				Defines:            target.Defines,
+				CompilerFlags:      target.CompilerFlags,
+				CompilerFlagsC:     target.CompilerFlagsC,
+				CompilerFlagsCC:    target.CompilerFlagsCC,
+				CompilerFlagsObjC:  target.CompilerFlagsObjC,
+				CompilerFlagsObjCC: target.CompilerFlagsObjCC,
+				AssemblerFlags:     target.AssemblerFlags,
+				NasmFlags:          target.NasmFlags,
+				ResourceFlags:      target.ResourceFlags,
+				SourceSettings:     normalizeSourceSettings(baseDir, target.SourceSettings),
+				Actions:            normalizeActions(baseDir, target.Actions),
+				LinkerFlags:        target.LinkerFlags,
+				Frameworks:         target.Frameworks,
+				Pool:               target.Pool,
+				Host:               target.Host,
+				Emscripten:         normalizeEmscriptenSettings(baseDir, target.Emscripten),
+				Install:            normalizeInstallSettings(baseDir, target.Install),
+				External:           normalizeExternalSettings(baseDir, target.External),
+				MSBuildSettings:    target.MSBuildSettings,
+				MSBuildProject:     target.MSBuildProject,
+				Templates:          target.Templates,
+			}
+
+			if outputType == OutputTypeTest {
+				node.Test = TestSettings{
+					Args:    target.Test.Args,
+					Timeout: target.Test.Timeout,
+					Labels:  target.Test.Labels,
+				}
+				if len(target.Test.WorkingDir) > 0 {
+					node.Test.WorkingDir = filepath.Clean(filepath.Join(baseDir, target.Test.WorkingDir))
+				}
+			}
+
+			if outputType == OutputTypeAction && len(target.Command) > 0 {
+				action := normalizeActions(baseDir, []Action{{
+					Name:        target.Name,
+					Command:     target.Command,
+					Description: target.Description,
+					Inputs:      target.Inputs,
+					Outputs:     target.Outputs,
+					DepFile:     target.DepFile,
+				}})
+				node.Actions = append(action, node.Actions...)
 			}
 
 			node.Tagged = map[string]*Node{}
 			for tag, tagged := range target.Tagged {
 				node.Tagged[tag] = &Node{
-					Headers:         normalizePathList(baseDir, tagged.Headers),
-					Sources:         normalizePathList(baseDir, tagged.Sources),
-					IncludeDirs:     normalizePathList(baseDir, tagged.IncludeDirs),
-					LibDirs:         normalizePathList(baseDir, tagged.LibDirs),
-					Defines:         tagged.Defines,
-					CompilerFlags:   tagged.CompilerFlags,
-					CompilerFlagsC:  tagged.CompilerFlagsC,
-					CompilerFlagsCC: tagged.CompilerFlagsCC,
-					LinkerFlags:     tagged.LinkerFlags,
-					MSBuildSettings: tagged.MSBuildSettings,
-					Templates:       tagged.Templates,
+					Headers:            normalizePathList(baseDir, tagged.Headers),
+					Sources:            normalizePathList(baseDir, tagged.Sources),
+					IncludeDirs:        normalizePathList(baseDir, tagged.IncludeDirs),
+					LibDirs:            normalizePathList(baseDir, tagged.LibDirs),
+					Libraries:          normalizePathList(baseDir, tagged.Libraries),
+					Defines:            tagged.Defines,
+					CompilerFlags:      tagged.CompilerFlags,
+					CompilerFlagsC:     tagged.CompilerFlagsC,
+					CompilerFlagsCC:    tagged.CompilerFlagsCC,
+					CompilerFlagsObjC:  tagged.CompilerFlagsObjC,
+					CompilerFlagsObjCC: tagged.CompilerFlagsObjCC,
+					AssemblerFlags:     tagged.AssemblerFlags,
+					NasmFlags:          tagged.NasmFlags,
+					ResourceFlags:      tagged.ResourceFlags,
+					SourceSettings:     normalizeSourceSettings(baseDir, tagged.SourceSettings),
+					Actions:            normalizeActions(baseDir, tagged.Actions),
+					LinkerFlags:        tagged.LinkerFlags,
+					Frameworks:         tagged.Frameworks,
+					Pool:               tagged.Pool,
+					Emscripten:         normalizeEmscriptenSettings(baseDir, tagged.Emscripten),
+					Install:            normalizeInstallSettings(baseDir, tagged.Install),
+					External:           normalizeExternalSettings(baseDir, tagged.External),
+					MSBuildSettings:    tagged.MSBuildSettings,
+					Templates:          tagged.Templates,
 				}
 			}
 
@@ -148,6 +384,7 @@ func parseGraph(manifestFile string) (*Graph, error) {
 		}
 
 		manifestMap[normalized] = &manifest
+		manifestFileList = append(manifestFileList, normalized)
 
 		requiredManifests = normalizePathList(baseDir, requiredManifests)
 		manifestFiles = append(requiredManifests, manifestFiles...)
@@ -191,8 +428,72 @@ func parseGraph(manifestFile string) (*Graph, error) {
 	}
 
 	graph := &Graph{
-		Nodes:   orderedNodes,
-		Sources: sourceNodes,
+		Nodes:          orderedNodes,
+		Sources:        sourceNodes,
+		FileTypes:      fileTypes,
+		ManifestFiles:  manifestFileList,
+		OutDir:         outDir,
+		InstallPrefix:  installPrefix,
+		Configurations: configurations,
+		Platforms:      platforms,
+		Toolchains:     toolchains,
+		Pools:          pools,
+
+		HostPlatform:     hostPlatform,
+		CompilerLauncher: compilerLauncher,
+	}
+
+	warnings, err := validateGraph(graph)
+	for _, warning := range warnings {
+		fmt.Println("warning:", warning)
+	}
+	if err != nil {
+		return nil, err
 	}
 	return graph, nil
 }
+
+// validateGraph returns the warnings about the targets and an error
+// if some target has settings that cannot be used.
+func validateGraph(graph *Graph) (warnings []string, err error) {
+	validate := func(node *Node, files *Node) {
+		for _, src := range files.Sources {
+			switch fileType := graph.FileTypes.Get(src); {
+			case fileType == SourceFileTypeUnknown:
+				warnings = append(warnings, fmt.Sprintf("%s: Unknown file type \"%s\" in sources", node.Name, src))
+			case fileType == SourceFileTypeCppHeader:
+				warnings = append(warnings, fmt.Sprintf("%s: Header file \"%s\" is listed in sources", node.Name, src))
+			}
+		}
+		for _, header := range files.Headers {
+			if isSourceFileType(graph.FileTypes.Get(header)) {
+				warnings = append(warnings, fmt.Sprintf("%s: Source file \"%s\" is listed in headers", node.Name, header))
+			}
+		}
+		if len(files.Pool) > 0 && !isKnownPool(graph, files.Pool) && err == nil {
+			err = errors.Errorf("%s: Pool \"%s\" is not declared", node.Name, files.Pool)
+		}
+		for _, settings := range files.SourceSettings {
+			if _, matchErr := filepath.Match(settings.Glob, ""); matchErr != nil && err == nil {
+				err = errors.Errorf("%s: Invalid glob \"%s\" in source settings", node.Name, settings.Glob)
+			}
+		}
+	}
+
+	for _, node := range graph.Nodes {
+		if (node.Name == allTargetName || node.Name == installTargetName) && err == nil {
+			err = errors.Errorf("Target name \"%s\" is reserved", node.Name)
+		}
+		validate(node, node)
+
+		tags := make([]string, 0, len(node.Tagged))
+		for tag := range node.Tagged {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			validate(node, node.Tagged[tag])
+		}
+	}
+	return warnings, err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateGraph(t *testing.T) {
+	node := &Node{
+		Name:    "a",
+		Headers: []string{"a.h", "b.cpp"},
+		Sources: []string{"a.cpp", "a.cppp", "c.h", "d.cppm"},
+		Tagged: map[string]*Node{
+			"mac": &Node{
+				Sources: []string{"e.mm", "f.txt"},
+			},
+		},
+	}
+	graph := &Graph{
+		Nodes: []*Node{node},
+		FileTypes: SourceFileTypes{
+			".cppm": SourceFileTypeCppSource,
+		},
+	}
+
+	actual, err := validateGraph(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`a: Unknown file type "a.cppp" in sources`,
+		`a: Header file "c.h" is listed in sources`,
+		`a: Source file "b.cpp" is listed in headers`,
+		`a: Unknown file type "f.txt" in sources`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected warnings:\n%v", actual)
+	}
+}
+
+func TestValidateGraphInvalidGlob(t *testing.T) {
+	node := &Node{
+		Name:    "a",
+		Sources: []string{"a.cpp"},
+		Tagged: map[string]*Node{
+			"linux": &Node{
+				SourceSettings: []SourceSettings{{Glob: "src/[a-"}},
+			},
+		},
+	}
+	graph := &Graph{Nodes: []*Node{node}}
+
+	_, err := validateGraph(graph)
+	if err == nil || err.Error() != `a: Invalid glob "src/[a-" in source settings` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSourceFileTypesGet(t *testing.T) {
+	fileTypes := SourceFileTypes{
+		".inl": SourceFileTypeCppHeader,
+		".c":   SourceFileTypeCppSource,
+	}
+	if actual := fileTypes.Get("a.inl"); actual != SourceFileTypeCppHeader {
+		t.Errorf("Unexpected file type: %v", actual)
+	}
+	if actual := fileTypes.Get("a.c"); actual != SourceFileTypeCppSource {
+		t.Errorf("Unexpected file type: %v", actual)
+	}
+	if actual := fileTypes.Get("a.m"); actual != SourceFileTypeObjC {
+		t.Errorf("Unexpected file type: %v", actual)
+	}
+}
+
+func TestValidateGraphReservedName(t *testing.T) {
+	graph := &Graph{Nodes: []*Node{&Node{Name: "all", Type: OutputTypeExecutable}}}
+	_, err := validateGraph(graph)
+	if err == nil || err.Error() != `Target name "all" is reserved` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateGraphUnknownPool(t *testing.T) {
+	graph := &Graph{
+		Nodes: []*Node{
+			&Node{Name: "a", Pool: "heavy"},
+			&Node{Name: "b", Pool: consolePoolName},
+			&Node{Name: "c", Tagged: map[string]*Node{"linux": &Node{Pool: "link_heavy"}}},
+		},
+		Pools: []Pool{{Name: "heavy", Depth: 1}},
+	}
+
+	_, err := validateGraph(graph)
+	if err == nil || err.Error() != `c: Pool "link_heavy" is not declared` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseGraphConfigurations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifests := map[string]string{
+		"build.toml": `
+[[configurations]]
+name = "Debug"
+platform = "x64"
+tags = ["debug"]
+
+[[configurations]]
+name = "Release"
+platform = "x64"
+tags = ["release"]
+
+[[targets]]
+name = "app"
+type = "executable"
+deps = ["lib.toml:lib"]
+sources = ["main.cpp"]
+`,
+		"lib.toml": `
+[[configurations]]
+name = "Release"
+platform = "x64"
+tags = ["release", "lib"]
+
+[[configurations]]
+name = "Release"
+platform = "ARM64"
+tags = ["release", "arm64"]
+
+[[targets]]
+name = "lib"
+type = "static_library"
+sources = ["lib.cpp"]
+`,
+	}
+	for name, text := range manifests {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph, err := parseGraph(filepath.Join(dir, "build.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Configuration{
+		{Name: "Debug", Platform: "x64", Tags: []string{"debug"}},
+		{Name: "Release", Platform: "x64", Tags: []string{"release"}},
+		{Name: "Release", Platform: "ARM64", Tags: []string{"release", "arm64"}},
+	}
+	if !reflect.DeepEqual(graph.Configurations, expected) {
+		t.Errorf("Unexpected configurations:\n%+v", graph.Configurations)
+	}
+}
+
+func TestParseGraphConfigurationWithoutName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifestFile := filepath.Join(dir, "build.toml")
+	if err := ioutil.WriteFile(manifestFile, []byte("[[configurations]]\ntags = [\"debug\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseGraph(manifestFile)
+	if err == nil || err.Error() != "A configuration has no name in "+manifestFile {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
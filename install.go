@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	installTargetName       = "install"
+	installManifestFileName = "install_manifest.txt"
+)
+
+// installFile represents a file that the install step copies into the prefix.
+type installFile struct {
+	Source      string
+	Destination string
+}
+
+// getInstallPrefix gets the directory that the install step copies the files into.
+// The files are staged in "<out>/install" unless the prefix is specified.
+func getInstallPrefix(env *Environment) string {
+	if len(env.InstallPrefix) > 0 {
+		return env.InstallPrefix
+	}
+	return filepath.Join(env.OutDir, "install")
+}
+
+// getInstallHeaderPath gets the path of the header relative to the include directories
+// so that the installed headers are included in the same way as in the source tree.
+func getInstallHeaderPath(env *Environment, node *Node, header string) string {
+	for _, dir := range node.GetIncludeDirs(env) {
+		if rel, err := filepath.Rel(dir, header); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(header)
+}
+
+// getInstallFiles gets the headers and the output of the node to install.
+// The output is built in nodeEnv, which is the host environment for the host targets.
+func getInstallFiles(env, nodeEnv *Environment, node *Node) (result []installFile) {
+	settings := node.GetInstallSettings(env)
+	prefix := getInstallPrefix(env)
+
+	includeDir := settings.IncludeDir
+	if len(includeDir) == 0 {
+		includeDir = "include"
+	}
+	for _, header := range settings.Headers {
+		result = append(result, installFile{
+			Source:      header,
+			Destination: filepath.Join(prefix, includeDir, getInstallHeaderPath(env, node, header)),
+		})
+	}
+
+	if !settings.Output {
+		return result
+	}
+	switch node.Type {
+	case OutputTypeExecutable, OutputTypeTest:
+		binDir := settings.BinDir
+		if len(binDir) == 0 {
+			binDir = "bin"
+		}
+		executableFile := getExecutableFile(nodeEnv, node)
+		files := []string{executableFile}
+		if isEmscripten(nodeEnv) {
+			// NOTE: The ".js" file cannot run without the files that em++ writes next to it.
+			emscripten := node.GetEmscriptenSettings(nodeEnv)
+			files = append(files, getEmscriptenOutputs(executableFile, &emscripten)...)
+		}
+		for _, file := range files {
+			result = append(result, installFile{
+				Source:      file,
+				Destination: filepath.Join(prefix, binDir, filepath.Base(file)),
+			})
+		}
+	case OutputTypeStaticLibrary:
+		libDir := settings.LibDir
+		if len(libDir) == 0 {
+			libDir = "lib"
+		}
+		libFile := getStaticLibraryFile(nodeEnv, node)
+		result = append(result, installFile{
+			Source:      libFile,
+			Destination: filepath.Join(prefix, libDir, filepath.Base(libFile)),
+		})
+	}
+	return result
+}
+
+// getUniqueInstallFiles removes the files listed more than once. It returns an error if
+// different files are installed into the same destination or into the output of another edge.
+func (gen *NinjaGenerator) getUniqueInstallFiles(files []installFile) (result []installFile, err error) {
+	sources := map[string]string{}
+	for _, file := range files {
+		if source, ok := sources[file.Destination]; ok {
+			if source == file.Source {
+				continue
+			}
+			return nil, errors.Errorf("\"%s\" and \"%s\" are installed into the same destination \"%s\"", source, file.Source, file.Destination)
+		}
+		if gen.HasOutput(file.Destination) {
+			return nil, errors.Errorf("Install destination \"%s\" of \"%s\" is the output of another build statement", file.Destination, file.Source)
+		}
+		sources[file.Destination] = file.Source
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+func (gen *NinjaGenerator) addInstallRules(toolchain *Toolchain) {
+	command := "cp -p $in $out"
+	if toolchain.Flavor == toolchainFlavorMSVC {
+		command = "cmd /c copy /Y $in $out >NUL"
+	}
+	gen.AddRule(&NinjaRule{
+		Name:    "install",
+		Command: command,
+	})
+
+	manifestCommand := "cp $out.rsp $out"
+	if toolchain.Flavor == toolchainFlavorMSVC {
+		manifestCommand = "cmd /c copy /Y $out.rsp $out >NUL"
+	}
+	gen.AddRule(&NinjaRule{
+		Name:           "install_manifest",
+		Command:        manifestCommand,
+		RspFile:        "$out.rsp",
+		RspFileContent: "$in_newline",
+	})
+}
+
+// generateInstall adds the edges that copy the files into the prefix and the "install"
+// target that writes the list of the installed files. "all" does not include them.
+func (gen *NinjaGenerator) generateInstall(env *Environment, graph *Graph) {
+	files := []installFile{}
+	envs := getNodeEnvironments(env, graph.Nodes)
+	for _, node := range graph.Nodes {
+		files = append(files, getInstallFiles(env, envs[node], node)...)
+		if isPackageNode(env, node) {
+			files = append(files, gen.generatePackageFiles(env, envs[node], node)...)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	files, err := gen.getUniqueInstallFiles(files)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	gen.addInstallRules(getEnvironmentToolchain(env))
+
+	installedFiles := []string{}
+	for _, file := range files {
+		gen.AddNode(&NinjaBuild{
+			Rule:    "install",
+			Inputs:  []string{file.Source},
+			Outputs: []string{file.Destination},
+		})
+		installedFiles = append(installedFiles, file.Destination)
+	}
+
+	manifestFile := filepath.Join(getInstallPrefix(env), installManifestFileName)
+	gen.AddNode(&NinjaBuild{
+		Rule:    "install_manifest",
+		Inputs:  installedFiles,
+		Outputs: []string{manifestFile},
+	})
+	gen.addPhony(installTargetName, []string{manifestFile})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetInstallFiles(t *testing.T) {
+	node := &Node{
+		Name:        "engine",
+		Type:        OutputTypeStaticLibrary,
+		IncludeDirs: []string{"engine/include"},
+		Install: InstallSettings{
+			Output:  true,
+			Headers: []string{"engine/include/engine/engine.h", "engine/src/config.h"},
+			LibDir:  "lib/x64",
+		},
+	}
+	env := &Environment{OutDir: "out"}
+
+	expected := []installFile{
+		{Source: "engine/include/engine/engine.h", Destination: "out/install/include/engine/engine.h"},
+		{Source: "engine/src/config.h", Destination: "out/install/include/config.h"},
+		{Source: "out/bin/libengine.a", Destination: "out/install/lib/x64/libengine.a"},
+	}
+	if actual := getInstallFiles(env, env, node); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	env.InstallPrefix = "sdk"
+	node.Install.Output = false
+	if actual := getInstallFiles(env, env, node); len(actual) != 2 || actual[0].Destination != "sdk/include/engine/engine.h" {
+		t.Errorf("Unexpected files: %v", actual)
+	}
+}
+
+func TestGetInstallFilesEmscripten(t *testing.T) {
+	node := &Node{
+		Name:       "app",
+		Type:       OutputTypeExecutable,
+		Install:    InstallSettings{Output: true},
+		Emscripten: EmscriptenSettings{PreloadFiles: []string{"assets@/assets"}},
+	}
+	env := &Environment{OutDir: "out", Toolchain: getBuiltinToolchain(wasmToolchainName)}
+
+	expected := []installFile{
+		{Source: "out/bin/app.js", Destination: "out/install/bin/app.js"},
+		{Source: "out/bin/app.wasm", Destination: "out/install/bin/app.wasm"},
+		{Source: "out/bin/app.data", Destination: "out/install/bin/app.data"},
+	}
+	if actual := getInstallFiles(env, env, node); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestGetUniqueInstallFiles(t *testing.T) {
+	generator := &NinjaGenerator{}
+	generator.AddNode(&NinjaBuild{Rule: "phony", Outputs: []string{"out/install/bin/tool"}, Inputs: []string{"tool"}})
+
+	files := []installFile{
+		{Source: "a/common.h", Destination: "out/install/include/common.h"},
+		{Source: "a/common.h", Destination: "out/install/include/common.h"},
+	}
+	actual, err := generator.getUniqueInstallFiles(files)
+	if err != nil || !reflect.DeepEqual(actual, files[:1]) {
+		t.Errorf("Unexpected files: %v %v", actual, err)
+	}
+
+	_, err = generator.getUniqueInstallFiles(append(files, installFile{Source: "b/common.h", Destination: "out/install/include/common.h"}))
+	if err == nil || err.Error() != `"a/common.h" and "b/common.h" are installed into the same destination "out/install/include/common.h"` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = generator.getUniqueInstallFiles([]installFile{{Source: "out/bin/tool", Destination: "out/install/bin/tool"}})
+	if err == nil || err.Error() != `Install destination "out/install/bin/tool" of "out/bin/tool" is the output of another build statement` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -13,24 +18,238 @@ type Environment struct {
 	OutDir         string
 	ProjectFileDir string
 	Tags           []string
+	ConfigName     string
+	Toolchain      *Toolchain
+	Features       []string
+	Platform       *Platform
+	InstallPrefix  string
+
+	// FeatureFlags are the flags of the features resolved for the toolchain.
+	FeatureFlags []Feature
+
+	// Host is the environment that builds the host targets when cross compiling.
+	Host *Environment
+
+	// RuleSuffix is appended to the names of the built-in rules that the environment uses.
+	RuleSuffix string
 }
 
-func generateNinja(manifestFile string, ninjaFile string, tags []string) {
-	graph, err := parseGraph(manifestFile)
+// parseConfiguration parses a configuration flag such as "Release=mac,release".
+func parseConfiguration(str string) (*Configuration, error) {
+	s := strings.SplitN(str, "=", 2)
+	if len(s) != 2 || len(s[0]) == 0 {
+		return nil, errors.Errorf("Invalid configuration \"%s\", expected \"<name>=<tag>,<tag>...\"", str)
+	}
+	config := &Configuration{Name: s[0]}
+	for _, tag := range strings.Split(s[1], ",") {
+		if len(tag) > 0 {
+			config.Tags = append(config.Tags, tag)
+		}
+	}
+	return config, nil
+}
+
+// resolveConfiguration gets the configuration specified by "<name>=<tag>,<tag>..."
+// or by the name of a configuration in the manifests such as "Release" and "Release|x64".
+func resolveConfiguration(graph *Graph, str string) (*Configuration, error) {
+	if strings.Contains(str, "=") {
+		return parseConfiguration(str)
+	}
+
+	var result *Configuration
+	for i, config := range graph.Configurations {
+		if config.Name != str && config.Name+"|"+config.Platform != str {
+			continue
+		}
+		if result != nil {
+			return nil, errors.Errorf("Configuration \"%s\" is ambiguous, specify \"%s|<platform>\"", str, str)
+		}
+		result = &graph.Configurations[i]
+	}
+	if result == nil {
+		return nil, errors.Errorf("Configuration \"%s\" is not defined", str)
+	}
+	return result, nil
+}
+
+// getOutDir gets the output directory. The "${tags}" in the pattern is replaced
+// with the tags joined by "-" so that each tag set has its own output tree.
+func getOutDir(graph *Graph, outDir string, tags []string) string {
+	if len(outDir) == 0 {
+		outDir = graph.OutDir
+	}
+	if len(outDir) == 0 {
+		outDir = "out"
+	}
+	tagsDir := strings.Join(tags, "-")
+	if len(tagsDir) == 0 {
+		tagsDir = "default"
+	}
+	return filepath.Clean(strings.Replace(outDir, "${tags}", tagsDir, -1))
+}
+
+// NinjaOptions specifies how to generate the ninja file.
+type NinjaOptions struct {
+	NinjaFile string
+	OutDir    string
+	Tags      []string
+	Toolchain string
+	Launcher  string
+	Features  []string
+
+	// Prefix is the directory that the install step copies the files into.
+	Prefix string
+
+	// Platform is the platform that the targets are built for.
+	Platform string
+
+	// HostPlatform is the platform that the host targets are built for.
+	HostPlatform string
+
+	// Config is the name of the configuration for a single configuration build.
+	Config string
+
+	// Configs are the configurations that the ninja file covers.
+	Configs []string
+}
+
+// getLaunchedToolchain gets the toolchain with the compiler launcher specified
+// by the flag, the toolchain or the manifests in that order.
+func getLaunchedToolchain(graph *Graph, name, launcher string) *Toolchain {
+	toolchain, err := getToolchain(graph, name)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
+	if len(launcher) > 0 {
+		toolchain.CompilerLauncher = launcher
+	} else if len(toolchain.CompilerLauncher) == 0 {
+		toolchain.CompilerLauncher = graph.CompilerLauncher
+	}
+	return toolchain
+}
 
-	env := &Environment{
-		OutDir:         "out",
+// newNinjaEnvironmentFromOptions creates the environment for the configuration
+// specified by the options. The tags are added to the tags of the configuration.
+func newNinjaEnvironmentFromOptions(graph *Graph, options *NinjaOptions) *Environment {
+	var config *Configuration
+	if len(options.Config) > 0 {
+		var err error
+		if config, err = resolveConfiguration(graph, options.Config); err != nil {
+			log.Fatalln("error:", err)
+		}
+	}
+
+	var platform *Platform
+	platformName := options.Platform
+	if len(platformName) == 0 && config != nil && hasPlatform(graph.Platforms, config.Platform) {
+		platformName = config.Platform
+	}
+	if len(platformName) > 0 {
+		var err error
+		if platform, err = getPlatform(graph, platformName); err != nil {
+			log.Fatalln("error:", err)
+		}
+	}
+
+	var env *Environment
+	if config == nil {
+		tags := options.Tags
+		if platform != nil {
+			tags = removeDuplicatesFromSlice(append(append([]string{}, platform.Tags...), tags...))
+		}
+		env = newNinjaEnvironment(options.NinjaFile, getOutDir(graph, options.OutDir, tags), tags)
+		env.Features = options.Features
+	} else {
+		tags := append(append([]string{}, config.Tags...), options.Tags...)
+		if platform != nil {
+			tags = removeDuplicatesFromSlice(append(tags, platform.Tags...))
+		}
+		env = newNinjaEnvironment(options.NinjaFile, getConfigOutDir(graph, options.OutDir, config), tags)
+		env.Features = removeDuplicatesFromSlice(append(append([]string{}, config.Features...), options.Features...))
+	}
+
+	if config != nil && len(options.Configs) > 0 {
+		// NOTE: The configurations in one ninja file are told apart by their names,
+		// so the name must be set before the host environment copies it.
+		env.ConfigName = config.Name
+	}
+
+	toolchainName := options.Toolchain
+	if platform != nil {
+		env.Platform = platform
+		if len(toolchainName) == 0 {
+			toolchainName = platform.Toolchain
+		}
+		env.Host = newHostEnvironment(graph, options, env)
+	}
+	env.Toolchain = getLaunchedToolchain(graph, toolchainName, options.Launcher)
+
+	env.InstallPrefix = options.Prefix
+	if len(env.InstallPrefix) == 0 {
+		env.InstallPrefix = graph.InstallPrefix
+	}
+
+	for _, name := range env.Features {
+		feature, err := env.Toolchain.GetFeature(name)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		env.FeatureFlags = append(env.FeatureFlags, feature)
+	}
+	return env
+}
+
+// newHostEnvironment creates the environment that builds the host targets such as
+// code generators for the build machine. The host targets are placed in "<out>/host"
+// and have the "host" tag and the tags of the host platform instead of the target ones.
+func newHostEnvironment(graph *Graph, options *NinjaOptions, env *Environment) *Environment {
+	host := &Environment{
+		OutDir:         filepath.Join(env.OutDir, "host"),
+		ProjectFileDir: env.ProjectFileDir,
+		Tags:           []string{hostTag},
+		ConfigName:     env.ConfigName,
+		RuleSuffix:     hostRuleSuffix,
+	}
+
+	toolchainName := ""
+	platformName := options.HostPlatform
+	if len(platformName) == 0 {
+		platformName = graph.HostPlatform
+	}
+	if len(platformName) > 0 {
+		platform, err := getPlatform(graph, platformName)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		host.Platform = platform
+		host.Tags = removeDuplicatesFromSlice(append(host.Tags, platform.Tags...))
+		toolchainName = platform.Toolchain
+	}
+	host.Toolchain = getLaunchedToolchain(graph, toolchainName, options.Launcher)
+	return host
+}
+
+func newNinjaEnvironment(ninjaFile, outDir string, tags []string) *Environment {
+	return &Environment{
+		OutDir:         outDir,
 		ProjectFileDir: filepath.Dir(ninjaFile),
 		Tags:           tags,
 	}
+}
 
+func writeNinja(env *Environment, graph *Graph, ninjaFile, stamp string) {
 	generator := &NinjaGenerator{}
 	generator.Generate(env, graph)
 
-	err = generator.WriteFile(ninjaFile)
+	err := generator.WriteFile(ninjaFile)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	err = generator.WriteGeneratedFiles()
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	err = writeNinjaStamp(ninjaFile, stamp)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
@@ -38,15 +257,180 @@ func generateNinja(manifestFile string, ninjaFile string, tags []string) {
 	fmt.Println("Generate", ninjaFile)
 }
 
-func generateMSBuild(manifestFile, outputGenDir string) {
+// generateNinja generates the ninja file. If onlyIfChanged is true, the ninja file
+// is regenerated only when the manifests or the arguments are changed.
+func generateNinja(manifestFile string, options *NinjaOptions, onlyIfChanged bool) (*Environment, *Graph) {
 	graph, err := parseGraph(manifestFile)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
 
+	env := newNinjaEnvironmentFromOptions(graph, options)
+
+	stamp := getNinjaStamp(manifestFile, env)
+	if !onlyIfChanged || needsRegenerateNinja(options.NinjaFile, stamp, graph) {
+		writeNinja(env, graph, options.NinjaFile, stamp)
+	}
+	return env, graph
+}
+
+// getConfigOutDir gets the output directory of the configuration.
+// The configuration name is appended unless the pattern contains "${config}".
+func getConfigOutDir(graph *Graph, outDir string, config *Configuration) string {
+	if len(outDir) == 0 {
+		outDir = graph.OutDir
+	}
+	if len(outDir) == 0 {
+		outDir = "out"
+	}
+	if !strings.Contains(outDir, "${config}") {
+		outDir = filepath.Join(outDir, "${config}")
+	}
+	return getOutDir(graph, strings.Replace(outDir, "${config}", config.Name, -1), config.Tags)
+}
+
+// generateMultiConfigNinja generates the ninja file that covers several configurations.
+func generateMultiConfigNinja(manifestFile string, options *NinjaOptions) {
+	graph, err := parseGraph(manifestFile)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	envs := []*Environment{}
+	stamp := ""
+	names := map[string]bool{}
+	for _, name := range options.Configs {
+		configOptions := *options
+		configOptions.Config = name
+		env := newNinjaEnvironmentFromOptions(graph, &configOptions)
+
+		config, _ := resolveConfiguration(graph, name)
+		if names[config.Name] {
+			log.Fatalf("error: Configuration \"%s\" is defined more than once.", config.Name)
+		}
+		names[config.Name] = true
+
+		// NOTE: The configurations share the built-in rules, which run one toolchain
+		// for the targets and one for the host targets.
+		if len(envs) > 0 && env.Toolchain.Name != envs[0].Toolchain.Name {
+			log.Fatalf("error: Configuration \"%s\" uses toolchain \"%s\" but \"%s\" uses \"%s\". The configurations in one ninja file must use the same toolchain.",
+				config.Name, env.Toolchain.Name, envs[0].ConfigName, envs[0].Toolchain.Name)
+		}
+		if env.Host != nil {
+			for _, other := range envs {
+				if other.Host != nil && env.Host.Toolchain.Name != other.Host.Toolchain.Name {
+					log.Fatalf("error: Configuration \"%s\" uses host toolchain \"%s\" but \"%s\" uses \"%s\". The configurations in one ninja file must use the same host toolchain.",
+						config.Name, env.Host.Toolchain.Name, other.ConfigName, other.Host.Toolchain.Name)
+				}
+			}
+		}
+
+		envs = append(envs, env)
+		stamp += fmt.Sprintln("config =", config.Name)
+		stamp += getNinjaStamp(manifestFile, env)
+	}
+
+	generator := &NinjaGenerator{}
+	generator.GenerateConfigs(envs, graph)
+
+	if err := generator.WriteFile(options.NinjaFile); err != nil {
+		log.Fatalln("error:", err)
+	}
+	if err := generator.WriteGeneratedFiles(); err != nil {
+		log.Fatalln("error:", err)
+	}
+	if err := writeNinjaStamp(options.NinjaFile, stamp); err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	fmt.Println("Generate", options.NinjaFile)
+}
+
+func runBuildCommand(manifestFile string, options *NinjaOptions, names []string, jobs int, native bool, cache *BuildCache) {
+	if native {
+		runNativeBuild(manifestFile, options, names, jobs, cache)
+		return
+	}
+
+	env, graph := generateNinja(manifestFile, options, true)
+
+	err := runNinja(options.NinjaFile, getNinjaTargets(env, graph, names), jobs)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		// NOTE: ninja prints the errors of the build, but not the ones of starting itself.
+		fmt.Println("error:", err)
+	}
+	os.Exit(getExitCode(err))
+}
+
+func runNativeBuild(manifestFile string, options *NinjaOptions, names []string, jobs int, cache *BuildCache) {
+	graph, err := parseGraph(manifestFile)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	env := newNinjaEnvironmentFromOptions(graph, options)
+
+	generator := &NinjaGenerator{}
+	generator.Generate(env, graph)
+	if err := generator.WriteGeneratedFiles(); err != nil {
+		log.Fatalln("error:", err)
+	}
+
+	executor := &NinjaExecutor{
+		Generator: generator,
+		LogFile:   filepath.Join(env.OutDir, ".baselard_log"),
+		Jobs:      jobs,
+		Cache:     cache,
+	}
+	if err := executor.Build(getNinjaTargets(env, graph, names)); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func runTestCommand(manifestFile string, options *NinjaOptions, names []string, testOptions *TestOptions) {
+	env, graph := generateNinja(manifestFile, options, true)
+
+	ok, err := runTests(env, graph, options.NinjaFile, names, testOptions)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func generateMSBuild(manifestFile, outputGenDir, launcher string, features []string) {
+	graph, err := parseGraph(manifestFile)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	if len(launcher) > 0 {
+		graph.CompilerLauncher = launcher
+	}
+	if len(graph.CompilerLauncher) > 0 && !isMSBuildCompilerLauncher(graph.CompilerLauncher) {
+		fmt.Println("warning: MSBuild ignores the compiler launcher that is not a drop-in replacement of cl.exe:", graph.CompilerLauncher)
+	} else if len(strings.Fields(graph.CompilerLauncher)) > 1 {
+		fmt.Println("warning: MSBuild ignores the arguments of the compiler launcher", graph.CompilerLauncher)
+	}
+
+	for _, feature := range features {
+		if _, err := msvcToolchain.GetFeature(feature); err != nil {
+			log.Fatalln("error:", err)
+		}
+	}
+	for _, config := range graph.Configurations {
+		for _, feature := range config.Features {
+			if _, err := msvcToolchain.GetFeature(feature); err != nil {
+				fmt.Printf("warning: %s: %s\n", config.Name, err)
+			}
+		}
+	}
+
 	env := &Environment{
 		OutDir:         "out",
 		ProjectFileDir: outputGenDir,
+		Features:       features,
 	}
 
 	generator := &MSBuildGenerator{}
@@ -60,33 +444,105 @@ func generateMSBuild(manifestFile, outputGenDir string) {
 
 func main() {
 	var manifestFile string
-	var outputNinjaFile string
 	var outputGenDir string
-	var tags []string
+	var launcher string
+	var jobs int
+	var native bool
+	var cacheDir string
+	var cacheSize int64
+	ninjaOptions := &NinjaOptions{}
+	testOptions := &TestOptions{}
+
+	addNinjaFlags := func(cmd *cobra.Command) {
+		cmd.Flags().StringArrayVarP(&ninjaOptions.Tags, "tag", "t", nil, "specify tags")
+		cmd.Flags().StringVarP(&ninjaOptions.NinjaFile, "file", "f", "build.ninja", "specify a output ninja file")
+		cmd.Flags().StringVar(&ninjaOptions.OutDir, "out-dir", "", "specify a output directory (e.g. out/${tags})")
+		cmd.Flags().StringVar(&ninjaOptions.Prefix, "prefix", "", "specify a directory to install files (default \"<out-dir>/install\")")
+		cmd.Flags().StringVar(&ninjaOptions.Platform, "platform", "", "specify a platform defined in the manifests to cross compile for")
+		cmd.Flags().StringVar(&ninjaOptions.HostPlatform, "host-platform", "", "specify a platform defined in the manifests for the host targets")
+		cmd.Flags().StringVar(&ninjaOptions.Toolchain, "toolchain", "", "specify a toolchain (default \"clang\")")
+		cmd.Flags().StringVar(&ninjaOptions.Launcher, "launcher", "", "specify a compiler launcher such as ccache")
+		cmd.Flags().StringArrayVar(&ninjaOptions.Features, "feature", nil, "enable a feature (asan, ubsan, tsan, coverage or lto)")
+	}
 
 	var ninjaCmd = &cobra.Command{
 		Use:   "ninja",
 		Short: "Generate ninja file",
 		Long:  `Ganerate ninja file.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			generateNinja(manifestFile, outputNinjaFile, tags)
+			if len(ninjaOptions.Configs) > 0 {
+				generateMultiConfigNinja(manifestFile, ninjaOptions)
+				return
+			}
+			generateNinja(manifestFile, ninjaOptions, false)
 		},
 	}
-	ninjaCmd.Flags().StringArrayVarP(&tags, "tag", "t", tags, "specify tags")
-	ninjaCmd.Flags().StringVarP(&outputNinjaFile, "file", "f", "build.ninja", "specify a output ninja file")
+	addNinjaFlags(ninjaCmd)
+	ninjaCmd.Flags().StringArrayVar(&ninjaOptions.Configs, "config", nil, "specify a configuration by name or such as Release=mac,release")
 
 	var msbuildCmd = &cobra.Command{
 		Use:   "msbuild",
 		Short: "Generate Visual Studio projects",
 		Long:  `Ganerate Visual Studio solution and project files.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			generateMSBuild(manifestFile, outputGenDir)
+			generateMSBuild(manifestFile, outputGenDir, launcher, ninjaOptions.Features)
 		},
 	}
 	msbuildCmd.Flags().StringVarP(&outputGenDir, "gen-dir", "g", "out", "specify a directory for generated project files")
+	msbuildCmd.Flags().StringVar(&launcher, "launcher", "", "specify a drop-in replacement of cl.exe such as clcache")
+	msbuildCmd.Flags().StringArrayVar(&ninjaOptions.Features, "feature", nil, "enable a feature (asan or lto)")
+
+	var buildCmd = &cobra.Command{
+		Use:   "build [targets]",
+		Short: "Build targets with ninja",
+		Long:  `Generate ninja file if the manifests are changed and build targets with ninja.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var cache *BuildCache
+			if len(cacheDir) > 0 {
+				cache = &BuildCache{
+					Dir:     cacheDir,
+					MaxSize: cacheSize * 1024 * 1024,
+				}
+			}
+			runBuildCommand(manifestFile, ninjaOptions, args, jobs, native, cache)
+		},
+	}
+	addNinjaFlags(buildCmd)
+	buildCmd.Flags().StringVar(&ninjaOptions.Config, "config", "", "specify a configuration defined in the manifests")
+	buildCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "specify the number of jobs to run in parallel")
+	buildCmd.Flags().BoolVar(&native, "native", false, "build with the built-in executor instead of ninja")
+	buildCmd.Flags().StringVar(&cacheDir, "cache-dir", "", "specify a directory to cache compiled objects (--native only)")
+	buildCmd.Flags().Int64Var(&cacheSize, "cache-size", 5120, "specify the maximum size of the cache in megabytes")
+
+	var testCmd = &cobra.Command{
+		Use:   "test [targets]",
+		Short: "Build and run tests",
+		Long:  `Generate ninja file, build test targets with ninja and run them.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runTestCommand(manifestFile, ninjaOptions, args, testOptions)
+		},
+	}
+	addNinjaFlags(testCmd)
+	testCmd.Flags().StringVar(&ninjaOptions.Config, "config", "", "specify a configuration defined in the manifests")
+	testCmd.Flags().IntVarP(&testOptions.Jobs, "jobs", "j", runtime.NumCPU(), "specify the number of tests to run in parallel")
+	testCmd.Flags().StringArrayVarP(&testOptions.Labels, "label", "L", nil, "run only tests with the specified labels")
+	testCmd.Flags().StringVar(&testOptions.JUnitFile, "junit", "", "specify a output JUnit XML report file")
+
+	var installCmd = &cobra.Command{
+		Use:   "install",
+		Short: "Build and install targets",
+		Long:  `Build targets with ninja and copy the files specified by the install settings into the prefix.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runBuildCommand(manifestFile, ninjaOptions, []string{installTargetName}, jobs, native, nil)
+		},
+	}
+	addNinjaFlags(installCmd)
+	installCmd.Flags().StringVar(&ninjaOptions.Config, "config", "", "specify a configuration defined in the manifests")
+	installCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "specify the number of jobs to run in parallel")
+	installCmd.Flags().BoolVar(&native, "native", false, "build with the built-in executor instead of ninja")
 
 	var rootCmd = &cobra.Command{Use: "baselard"}
 	rootCmd.PersistentFlags().StringVarP(&manifestFile, "input", "i", "", "specify a manifest file")
-	rootCmd.AddCommand(ninjaCmd, msbuildCmd)
+	rootCmd.AddCommand(ninjaCmd, msbuildCmd, buildCmd, testCmd, installCmd)
 	rootCmd.Execute()
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewNinjaEnvironmentFromOptionsHostConfigName(t *testing.T) {
+	graph := &Graph{
+		Platforms:      []Platform{{Name: "arm", Triple: "aarch64-linux-gnu"}},
+		Configurations: []Configuration{{Name: "Debug", Platform: "arm"}},
+	}
+	options := &NinjaOptions{Config: "Debug", Configs: []string{"Debug"}}
+
+	env := newNinjaEnvironmentFromOptions(graph, options)
+	if env.ConfigName != "Debug" || env.Host == nil || env.Host.ConfigName != "Debug" {
+		t.Errorf("Unexpected configuration names: %+v", env)
+	}
+	if env.Host.OutDir != "out/Debug/host" {
+		t.Errorf("Unexpected host output directory: %s", env.Host.OutDir)
+	}
+}
+
+func TestGetOutDir(t *testing.T) {
+	for _, test := range []struct {
+		manifestOutDir string
+		outDir         string
+		tags           []string
+		expected       string
+	}{
+		{"", "", nil, "out"},
+		{"build", "", []string{"debug"}, "build"},
+		{"build", "out/custom", []string{"debug"}, "out/custom"},
+		{"out/${tags}", "", []string{"mac", "debug"}, "out/mac-debug"},
+		{"out/${tags}", "", nil, "out/default"},
+		{"", "out/${tags}/../${tags}", []string{"release"}, "out/release"},
+	} {
+		graph := &Graph{OutDir: test.manifestOutDir}
+		if actual := getOutDir(graph, test.outDir, test.tags); actual != test.expected {
+			t.Errorf("getOutDir(%q, %q, %v) = %s, expected %s", test.manifestOutDir, test.outDir, test.tags, actual, test.expected)
+		}
+	}
+}
+
+func TestGetConfigOutDir(t *testing.T) {
+	config := &Configuration{Name: "Release", Tags: []string{"windows", "release"}}
+	for _, test := range []struct {
+		manifestOutDir string
+		outDir         string
+		expected       string
+	}{
+		{"", "", "out/Release"},
+		{"build", "", "build/Release"},
+		{"", "out/${config}/bin", "out/Release/bin"},
+		{"out/${tags}", "", "out/windows-release/Release"},
+		{"", "out/${config}-${tags}", "out/Release-windows-release"},
+	} {
+		graph := &Graph{OutDir: test.manifestOutDir}
+		if actual := getConfigOutDir(graph, test.outDir, config); actual != test.expected {
+			t.Errorf("getConfigOutDir(%q, %q) = %s, expected %s", test.manifestOutDir, test.outDir, actual, test.expected)
+		}
+	}
+}
+
+func TestResolveConfiguration(t *testing.T) {
+	graph := &Graph{
+		Configurations: []Configuration{
+			{Name: "Debug", Platform: "x64", Tags: []string{"debug"}},
+			{Name: "Release", Platform: "x64", Tags: []string{"release"}},
+			{Name: "Release", Platform: "ARM64", Tags: []string{"release", "arm64"}},
+		},
+	}
+	for _, test := range []struct {
+		config   string
+		expected *Configuration
+	}{
+		{"Debug", &graph.Configurations[0]},
+		{"Debug|x64", &graph.Configurations[0]},
+		{"Release|ARM64", &graph.Configurations[2]},
+		{"Custom=mac,,release", &Configuration{Name: "Custom", Tags: []string{"mac", "release"}}},
+	} {
+		actual, err := resolveConfiguration(graph, test.config)
+		if err != nil {
+			t.Errorf("resolveConfiguration(%q) failed: %v", test.config, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("resolveConfiguration(%q) = %+v, expected %+v", test.config, actual, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		config   string
+		expected string
+	}{
+		{"Profile", "Configuration \"Profile\" is not defined"},
+		{"Debug|ARM64", "Configuration \"Debug|ARM64\" is not defined"},
+		{"Release", "Configuration \"Release\" is ambiguous, specify \"Release|<platform>\""},
+		{"=debug", "Invalid configuration \"=debug\", expected \"<name>=<tag>,<tag>...\""},
+	} {
+		_, err := resolveConfiguration(graph, test.config)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("resolveConfiguration(%q) returned unexpected error: %v", test.config, err)
+		}
+	}
+}
@@ -8,41 +8,129 @@ type Templates struct {
 	XcodeProject    string `toml:"xcodeproj"`
 }
 
+// SourceSettings defines configuration settings for the source files matching the glob pattern.
+type SourceSettings struct {
+	Glob          string   `toml:"glob"`
+	IncludeDirs   []string `toml:"include_dirs"`
+	Defines       []string `toml:"defines"`
+	CompilerFlags []string `toml:"cflags"`
+}
+
+// Action defines a custom command that generates files from the inputs.
+// The command can refer to the inputs and outputs as $in and $out.
+type Action struct {
+	Name        string   `toml:"name"`
+	Command     string   `toml:"command"`
+	Description string   `toml:"description"`
+	Inputs      []string `toml:"inputs"`
+	Outputs     []string `toml:"outputs"`
+	DepFile     string   `toml:"depfile"`
+}
+
+// TestSettings defines how to run a test executable.
+type TestSettings struct {
+	Args       []string `toml:"args"`
+	WorkingDir string   `toml:"working_dir"`
+	Timeout    int      `toml:"timeout"`
+	Labels     []string `toml:"labels"`
+}
+
+// EmscriptenSettings defines the link settings for executables built with Emscripten.
+type EmscriptenSettings struct {
+	ExportedFunctions []string          `toml:"exported_functions"`
+	InitialMemory     int               `toml:"initial_memory"`
+	AllowMemoryGrowth bool              `toml:"allow_memory_growth"`
+	PreloadFiles      []string          `toml:"preload_files"`
+	Settings          map[string]string `toml:"settings"`
+}
+
+// InstallSettings defines the files that the install step copies into the prefix.
+// The headers keep the paths relative to the include directories of the target.
+type InstallSettings struct {
+	Output     bool     `toml:"output"`
+	Headers    []string `toml:"headers"`
+	IncludeDir string   `toml:"include_dir"`
+	BinDir     string   `toml:"bin_dir"`
+	LibDir     string   `toml:"lib_dir"`
+	Version    string   `toml:"version"`
+}
+
+// ExternalSettings defines how to resolve the flags of a library installed in the system.
+// The packages are resolved with pkg-config unless the directories of .pc files are specified.
+type ExternalSettings struct {
+	Packages []string `toml:"packages"`
+	Paths    []string `toml:"paths"`
+	Static   bool     `toml:"static"`
+}
+
 // Tagged defines tagged configuration settings.
 type Tagged struct {
-	Headers         []string        `toml:"headers"`
-	Sources         []string        `toml:"sources"`
-	IncludeDirs     []string        `toml:"include_dirs"`
-	LibDirs         []string        `toml:"lib_dirs"`
-	Defines         []string        `toml:"defines"`
-	Dependencies    []string        `toml:"deps"`
-	CompilerFlags   []string        `toml:"cflags"`
-	CompilerFlagsC  []string        `toml:"cflags_c"`
-	CompilerFlagsCC []string        `toml:"cflags_cc"`
-	LinkerFlags     []string        `toml:"ldflags"`
-	MSBuildSettings MSBuildSettings `toml:"msbuild_settings"`
-	Templates       Templates       `toml:"templates"`
+	Headers            []string           `toml:"headers"`
+	Sources            []string           `toml:"sources"`
+	IncludeDirs        []string           `toml:"include_dirs"`
+	LibDirs            []string           `toml:"lib_dirs"`
+	Libraries          []string           `toml:"libs"`
+	Defines            []string           `toml:"defines"`
+	Dependencies       []string           `toml:"deps"`
+	CompilerFlags      []string           `toml:"cflags"`
+	CompilerFlagsC     []string           `toml:"cflags_c"`
+	CompilerFlagsCC    []string           `toml:"cflags_cc"`
+	CompilerFlagsObjC  []string           `toml:"cflags_objc"`
+	CompilerFlagsObjCC []string           `toml:"cflags_objcc"`
+	AssemblerFlags     []string           `toml:"asmflags"`
+	NasmFlags          []string           `toml:"nasmflags"`
+	ResourceFlags      []string           `toml:"rcflags"`
+	SourceSettings     []SourceSettings   `toml:"source_settings"`
+	Actions            []Action           `toml:"actions"`
+	LinkerFlags        []string           `toml:"ldflags"`
+	Frameworks         []string           `toml:"frameworks"`
+	Pool               string             `toml:"pool"`
+	Emscripten         EmscriptenSettings `toml:"emscripten"`
+	Install            InstallSettings    `toml:"install"`
+	External           ExternalSettings   `toml:"external"`
+	MSBuildSettings    MSBuildSettings    `toml:"msbuild_settings"`
+	Templates          Templates          `toml:"templates"`
 }
 
 // Target defines a build target and configuration settings.
 type Target struct {
-	Name            string            `toml:"name"`
-	Type            string            `toml:"type"`
-	Headers         []string          `toml:"headers"`
-	Sources         []string          `toml:"sources"`
-	IncludeDirs     []string          `toml:"include_dirs"`
-	LibDirs         []string          `toml:"lib_dirs"`
-	Defines         []string          `toml:"defines"`
-	CompilerFlags   []string          `toml:"cflags"`
-	CompilerFlagsC  []string          `toml:"cflags_c"`
-	CompilerFlagsCC []string          `toml:"cflags_cc"`
-	LinkerFlags     []string          `toml:"ldflags"`
-	MSBuildSettings MSBuildSettings   `toml:"msbuild_settings"`
-	Dependencies    []string          `toml:"deps"`
-	Configs         []string          `toml:"configs"`
-	Tagged          map[string]Tagged `toml:"tagged"`
-	MSBuildProject  MSBuildProject    `toml:"msbuild_project"`
-	Templates       Templates         `toml:"templates"`
+	Name               string             `toml:"name"`
+	Type               string             `toml:"type"`
+	Headers            []string           `toml:"headers"`
+	Sources            []string           `toml:"sources"`
+	IncludeDirs        []string           `toml:"include_dirs"`
+	LibDirs            []string           `toml:"lib_dirs"`
+	Libraries          []string           `toml:"libs"`
+	Defines            []string           `toml:"defines"`
+	CompilerFlags      []string           `toml:"cflags"`
+	CompilerFlagsC     []string           `toml:"cflags_c"`
+	CompilerFlagsCC    []string           `toml:"cflags_cc"`
+	CompilerFlagsObjC  []string           `toml:"cflags_objc"`
+	CompilerFlagsObjCC []string           `toml:"cflags_objcc"`
+	AssemblerFlags     []string           `toml:"asmflags"`
+	NasmFlags          []string           `toml:"nasmflags"`
+	ResourceFlags      []string           `toml:"rcflags"`
+	SourceSettings     []SourceSettings   `toml:"source_settings"`
+	Actions            []Action           `toml:"actions"`
+	Command            string             `toml:"command"`
+	Description        string             `toml:"description"`
+	Inputs             []string           `toml:"inputs"`
+	Outputs            []string           `toml:"outputs"`
+	DepFile            string             `toml:"depfile"`
+	Test               TestSettings       `toml:"test"`
+	LinkerFlags        []string           `toml:"ldflags"`
+	Frameworks         []string           `toml:"frameworks"`
+	Pool               string             `toml:"pool"`
+	Host               bool               `toml:"host"`
+	Emscripten         EmscriptenSettings `toml:"emscripten"`
+	Install            InstallSettings    `toml:"install"`
+	External           ExternalSettings   `toml:"external"`
+	MSBuildSettings    MSBuildSettings    `toml:"msbuild_settings"`
+	Dependencies       []string           `toml:"deps"`
+	Configs            []string           `toml:"configs"`
+	Tagged             map[string]Tagged  `toml:"tagged"`
+	MSBuildProject     MSBuildProject     `toml:"msbuild_project"`
+	Templates          Templates          `toml:"templates"`
 }
 
 // MSBuildSettings defines configuration settings for MSBuild.
@@ -61,6 +149,7 @@ type MSBuildProjectConfiguration struct {
 	Platform      string   `toml:"platform"`
 	Configuration string   `toml:"configuration"`
 	Tags          []string `toml:"tags"`
+	Features      []string `toml:"features"`
 
 	// TODO: Move the following definitions to out of MSBuildProjectConfiguration
 	ExecutableExtension     string `toml:"executable_extension"`
@@ -75,7 +164,32 @@ type MSBuildProject struct {
 	ExtensionTargets  []string                      `toml:"ExtensionTargets"`
 }
 
+// Configuration represents a named set of tags that is shared by all generators.
+type Configuration struct {
+	Name     string   `toml:"name"`
+	Platform string   `toml:"platform"`
+	Tags     []string `toml:"tags"`
+	Features []string `toml:"features"`
+}
+
+// Pool limits the number of build commands that run concurrently.
+// The pool is assigned to the built-in rules listed in Rules or to targets.
+type Pool struct {
+	Name  string   `toml:"name"`
+	Depth int      `toml:"depth"`
+	Rules []string `toml:"rules"`
+}
+
 // Manifest represents a input build settings.
 type Manifest struct {
-	Targets []Target `toml:"targets"`
+	OutDir           string            `toml:"out_dir"`
+	InstallPrefix    string            `toml:"install_prefix"`
+	CompilerLauncher string            `toml:"compiler_launcher"`
+	HostPlatform     string            `toml:"host_platform"`
+	Configurations   []Configuration   `toml:"configurations"`
+	Platforms        []Platform        `toml:"platforms"`
+	Toolchains       []Toolchain       `toml:"toolchains"`
+	Pools            []Pool            `toml:"pools"`
+	Targets          []Target          `toml:"targets"`
+	FileTypes        map[string]string `toml:"file_types"`
 }
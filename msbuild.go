@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
@@ -35,18 +36,208 @@ type MSBuildXMLExcludedFromBuild struct {
 	Excluded  bool
 }
 
+// MSBuildXMLMetadata represents a conditional item metadata used in *.vcxproj.
+type MSBuildXMLMetadata struct {
+	Name      string
+	Condition string
+	Value     string
+}
+
 // MSBuildXMLItem represents a XML element used in *.vcxproj.
 type MSBuildXMLItem struct {
+	ItemType          string
 	Include           string
 	ExcludedFromBuild []MSBuildXMLExcludedFromBuild
+	Metadata          []MSBuildXMLMetadata
 	Filter            string
 }
 
-func getClCompileSources(node *Node, project *MSBuildProject, env *Environment) (result []MSBuildXMLItem) {
+// msbuildCompileItemTypes lists the item types of the source files in the order they appear in *.vcxproj.
+var msbuildCompileItemTypes = []string{"CustomBuild", "ClCompile", "ResourceCompile", "None"}
+
+func getMSBuildItemType(fileType SourceFileType) string {
+	switch fileType {
+	case SourceFileTypeCSource, SourceFileTypeCppSource:
+		return "ClCompile"
+	case SourceFileTypeCppHeader:
+		return "ClInclude"
+	case SourceFileTypeResource:
+		return "ResourceCompile"
+	}
+	// NOTE: Objective-C, GNU assembly, NASM and unknown files cannot be built with the Visual C++
+	// toolchain. NASM sources are not MASM ones even if they have the same extension ".asm".
+	return "None"
+}
+
+func getMSBuildItemFilter(itemType string) string {
+	switch itemType {
+	case "ClCompile":
+		return "Graphics"
+	case "ResourceCompile":
+		return "Resource Files"
+	}
+	return "Source Files"
+}
+
+func hasMSBuildItemType(items []MSBuildXMLItem, itemType string) bool {
+	for _, item := range items {
+		if item.ItemType == itemType {
+			return true
+		}
+	}
+	return false
+}
+
+func getSourceSettingsMetadata(node *Node, env *Environment, src, condition string) (result []MSBuildXMLMetadata) {
+	var includeDirs, defines, cflags []string
+	for _, settings := range node.GetSourceSettings(env, src) {
+		includeDirs = append(includeDirs, settings.IncludeDirs...)
+		defines = append(defines, settings.Defines...)
+		cflags = append(cflags, settings.CompilerFlags...)
+	}
+
+	if len(includeDirs) > 0 {
+		str := ""
+		for _, dir := range includeDirs {
+			dir, _ = filepath.Rel(env.OutDir, dir)
+			str += dir
+			str += ";"
+		}
+		str += "%(AdditionalIncludeDirectories)"
+		result = append(result, MSBuildXMLMetadata{Name: "AdditionalIncludeDirectories", Condition: condition, Value: str})
+	}
+	if len(defines) > 0 {
+		str := strings.Join(defines, ";") + ";%(PreprocessorDefinitions)"
+		result = append(result, MSBuildXMLMetadata{Name: "PreprocessorDefinitions", Condition: condition, Value: str})
+	}
+	if len(cflags) > 0 {
+		str := strings.Join(cflags, " ") + " %(AdditionalOptions)"
+		result = append(result, MSBuildXMLMetadata{Name: "AdditionalOptions", Condition: condition, Value: str})
+	}
+	return result
+}
+
+var actionVariablePattern = regexp.MustCompile(`\$(in|out|bin_dir|host_bin_dir)\b`)
+
+// msbuildBinDir is the output directory of the projects without the trailing separator.
+// MSBuild builds the host targets into the same directory as the other targets.
+const msbuildBinDir = `$(OutDir.TrimEnd('\'))`
+
+func expandActionCommand(command string, inputs, outputs []string) string {
+	return actionVariablePattern.ReplaceAllStringFunc(command, func(s string) string {
+		switch s {
+		case "$in":
+			return strings.Join(inputs, " ")
+		case "$bin_dir", "$host_bin_dir":
+			return msbuildBinDir
+		}
+		return strings.Join(outputs, " ")
+	})
+}
+
+func getCustomBuildItems(node *Node, env *Environment) (result []MSBuildXMLItem) {
+	relPaths := func(paths []string) (result []string) {
+		for _, path := range paths {
+			path, _ = filepath.Rel(env.OutDir, path)
+			result = append(result, path)
+		}
+		return result
+	}
+
+	for _, action := range node.GetActions(env) {
+		if len(action.Inputs) == 0 {
+			fmt.Println("warning: Actions without inputs are not supported by MSBuild:", action.Command)
+			continue
+		}
+		inputs := relPaths(action.Inputs)
+		outputs := relPaths(action.Outputs)
+
+		item := MSBuildXMLItem{
+			ItemType: "CustomBuild",
+			Include:  inputs[0],
+		}
+		item.Metadata = append(item.Metadata, MSBuildXMLMetadata{Name: "Command", Value: expandActionCommand(action.Command, inputs, outputs)})
+		item.Metadata = append(item.Metadata, MSBuildXMLMetadata{Name: "Outputs", Value: strings.Join(outputs, ";")})
+		if len(inputs) > 1 {
+			item.Metadata = append(item.Metadata, MSBuildXMLMetadata{Name: "AdditionalInputs", Value: strings.Join(inputs[1:], ";")})
+		}
+		if len(action.Description) > 0 {
+			item.Metadata = append(item.Metadata, MSBuildXMLMetadata{Name: "Message", Value: action.Description})
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// msvcToolchain maps the features to the flags of MSVC.
+var msvcToolchain = getBuiltinToolchain(msvcToolchainName)
+
+func appendMSBuildOptions(settings map[string]string, options []string) {
+	if len(options) == 0 {
+		return
+	}
+	str := strings.Join(options, " ")
+	if v := settings["AdditionalOptions"]; len(v) > 0 {
+		str = v + " " + str
+	}
+	settings["AdditionalOptions"] = str
+}
+
+// msbuildCompilerLaunchers lists the launchers that are drop-in replacements of cl.exe.
+// The other launchers such as ccache and sccache take the compiler as the first argument.
+var msbuildCompilerLaunchers = map[string]bool{
+	"clcache": true,
+}
+
+// isMSBuildCompilerLauncher returns true if MSBuild can run the launcher instead of cl.exe.
+func isMSBuildCompilerLauncher(launcher string) bool {
+	fields := strings.Fields(launcher)
+	if len(fields) == 0 {
+		return false
+	}
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(fields[0]), ".exe"))
+	return msbuildCompilerLaunchers[name]
+}
+
+// setMSBuildCompilerLauncher replaces cl.exe with the launcher. Unlike ninja, MSBuild
+// cannot prefix the compiler, so only the drop-in replacements such as clcache are used.
+func setMSBuildCompilerLauncher(general map[string]string, launcher string) {
+	if !isMSBuildCompilerLauncher(launcher) {
+		return
+	}
+	fields := strings.Fields(launcher)
+	if _, ok := general["CLToolExe"]; ok {
+		return
+	}
+	general["CLToolExe"] = filepath.Base(fields[0])
+	if dir := filepath.Dir(fields[0]); dir != "." {
+		general["CLToolPath"] = dir
+	}
+}
+
+// getMSBuildConfigurations derives the MSBuild configurations from the configurations in the manifests.
+func getMSBuildConfigurations(configurations []Configuration) (result []MSBuildProjectConfiguration) {
+	for _, config := range configurations {
+		platform := config.Platform
+		if len(platform) == 0 {
+			platform = "x64"
+		}
+		result = append(result, MSBuildProjectConfiguration{
+			Configuration: config.Name,
+			Platform:      platform,
+			Tags:          append([]string{}, config.Tags...),
+			Features:      append([]string{}, config.Features...),
+		})
+	}
+	return result
+}
+
+func getCompileSources(node *Node, project *MSBuildProject, env *Environment, fileTypes SourceFileTypes) (result []MSBuildXMLItem) {
 	type SourceConditions struct {
 		Conditions map[string]bool
+		Metadata   []MSBuildXMLMetadata
 	}
-	sources := map[string]SourceConditions{}
+	sources := map[string]*SourceConditions{}
 
 	projectConditions := []string{}
 
@@ -62,17 +253,22 @@ func getClCompileSources(node *Node, project *MSBuildProject, env *Environment)
 
 		for _, src := range node.GetSources(projectEnv) {
 			if _, ok := sources[src]; !ok {
-				sources[src] = SourceConditions{
+				sources[src] = &SourceConditions{
 					Conditions: map[string]bool{},
 				}
 			}
 			sources[src].Conditions[condition] = true
+			sources[src].Metadata = append(sources[src].Metadata, getSourceSettingsMetadata(node, projectEnv, src, condition)...)
 		}
 	}
 
 	for src, conditions := range sources {
+		if fileTypes.Get(src) == SourceFileTypeNasm {
+			fmt.Printf("warning: %s: MSBuild does not assemble the NASM source \"%s\"\n", node.Name, src)
+		}
+		itemType := getMSBuildItemType(fileTypes.Get(src))
 		src, _ = filepath.Rel(env.OutDir, src)
-		item := MSBuildXMLItem{Include: src}
+		item := MSBuildXMLItem{ItemType: itemType, Include: src, Metadata: conditions.Metadata}
 
 		if len(project.Configurations) > len(conditions.Conditions) {
 			for cond := range conditions.Conditions {
@@ -102,12 +298,22 @@ func sortSubElements(elements []*MSBuildXMLElement) {
 	})
 }
 
+// hasMSBuildProject returns true if a vcxproj is generated for the node. The external
+// and prebuilt libraries have no projects but add the flags to the projects using them.
+func hasMSBuildProject(node *Node) bool {
+	switch node.Type {
+	case OutputTypeUnknown, OutputTypeExternal, OutputTypePrebuiltLibrary:
+		return false
+	}
+	return true
+}
+
 // Generate generates projects from a project dependency graph.
 func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 	projectSourceMap := map[*Node]*MSBuildProjectFile{}
 
 	for _, node := range graph.Nodes {
-		if node.Type == OutputTypeUnknown {
+		if !hasMSBuildProject(node) {
 			continue
 		}
 
@@ -124,7 +330,10 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 	}
 
 	for _, node := range graph.Nodes {
-		project := projectSourceMap[node]
+		project, ok := projectSourceMap[node]
+		if !ok {
+			continue
+		}
 
 		for _, dep := range node.Dependencies {
 			if depProject, ok := projectSourceMap[dep]; ok {
@@ -134,13 +343,16 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 	}
 
 	for _, node := range graph.Nodes {
-		if node.Type == OutputTypeUnknown {
+		if !hasMSBuildProject(node) {
 			continue
 		}
 
 		projectSource := projectSourceMap[node]
 
 		project := node.GetMSBuildProject(env)
+		if len(project.Configurations) == 0 {
+			project.Configurations = getMSBuildConfigurations(graph.Configurations)
+		}
 
 		var propertyGroupsConfigurations []*MSBuildXMLElement
 		var propertyGroupsGenerals []*MSBuildXMLElement
@@ -148,12 +360,14 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 
 		configurationType := func() string {
 			switch node.Type {
-			case OutputTypeExecutable:
+			case OutputTypeExecutable, OutputTypeTest:
 				return "Application"
 			case OutputTypeStaticLibrary:
 				return "StaticLibrary"
 			case OutputTypeDynamicLibrary:
 				return "DynamicLibrary"
+			case OutputTypeAction:
+				return "Utility"
 			}
 			return "Application"
 		}()
@@ -180,7 +394,7 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 
 			msbuild.ClCompile["AdditionalIncludeDirectories"] = func() string {
 				str := ""
-				for _, dir := range node.GetIncludeDirs(projectEnv) {
+				for _, dir := range append(node.GetIncludeDirs(projectEnv), getPrebuiltIncludeDirs(projectEnv, node)...) {
 					dir, _ = filepath.Rel(env.OutDir, dir)
 					str += dir
 					str += ";"
@@ -191,7 +405,7 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 
 			msbuild.ClCompile["PreprocessorDefinitions"] = func() string {
 				str := ""
-				for _, def := range node.GetDefines(projectEnv) {
+				for _, def := range append(node.GetDefines(projectEnv), getPrebuiltDefines(projectEnv, node)...) {
 					str += def
 					str += ";"
 				}
@@ -201,7 +415,7 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 
 			msbuildLinker := func() map[string]string {
 				switch node.Type {
-				case OutputTypeExecutable:
+				case OutputTypeExecutable, OutputTypeTest:
 					return msbuild.Link
 				case OutputTypeStaticLibrary:
 					return msbuild.Lib
@@ -209,6 +423,22 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 				return msbuild.Link
 			}()
 
+			for _, name := range removeDuplicatesFromSlice(append(append([]string{}, env.Features...), config.Features...)) {
+				feature, err := msvcToolchain.GetFeature(name)
+				if err != nil {
+					// NOTE: The features are validated before generating projects.
+					continue
+				}
+				appendMSBuildOptions(msbuild.ClCompile, feature.CompilerFlags)
+				appendMSBuildOptions(msbuildLinker, feature.LinkerFlags)
+			}
+
+			external := getExternalFlags(projectEnv, node)
+			appendMSBuildOptions(msbuild.ClCompile, translateMSVCCompilerFlags(external.CompilerFlags))
+			if node.Type != OutputTypeStaticLibrary {
+				appendMSBuildOptions(msbuildLinker, translateMSVCLinkerFlags(external.LinkerFlags))
+			}
+
 			msbuildLinker["AdditionalLibraryDirectories"] = func() string {
 				str := ""
 				for _, dir := range node.GetLibDirs(projectEnv) {
@@ -229,6 +459,13 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 						str += ";"
 					}
 				}
+				if node.Type != OutputTypeStaticLibrary {
+					for _, lib := range getPrebuiltLibraries(projectEnv, node) {
+						lib, _ = filepath.Rel(env.OutDir, lib)
+						str += lib
+						str += ";"
+					}
+				}
 				str += "%(AdditionalDependencies)"
 				return str
 			}()
@@ -255,6 +492,9 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 					xmlAttr("Condition", fmt.Sprintf("'$(Configuration)|$(Platform)'=='%s'", conditionStr)),
 				},
 			}
+			if node.Type != OutputTypeAction {
+				setMSBuildCompilerLauncher(msbuild.General, graph.CompilerLauncher)
+			}
 			for k, v := range msbuild.General {
 				propertyGroupsGeneral.SubElement(k).SetText(v)
 			}
@@ -328,6 +568,9 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 
 		vcxproj.Elements = append(vcxproj.Elements, propertyGroupsConfigurations...)
 
+		sourceItems := getCustomBuildItems(node, env)
+		sourceItems = append(sourceItems, getCompileSources(node, &project, env, graph.FileTypes)...)
+
 		vcxproj.SubElement("Import", xmlAttr("Project", `$(VCTargetsPath)\Microsoft.Cpp.props`))
 		extensionSettings := vcxproj.SubElement("ImportGroup", xmlAttr("Label", "ExtensionSettings"))
 		for _, s := range project.ExtensionSettings {
@@ -364,6 +607,11 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 				src, _ = filepath.Rel(env.OutDir, src)
 				result = append(result, MSBuildXMLItem{Include: src})
 			}
+			for _, v := range sourceItems {
+				if v.ItemType == "ClInclude" {
+					result = append(result, v)
+				}
+			}
 			return result
 		}()
 		{
@@ -373,14 +621,26 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 			}
 		}
 
-		clCompileSources := getClCompileSources(node, &project, env)
-		{
+		for _, itemType := range msbuildCompileItemTypes {
+			if itemType != "ClCompile" && !hasMSBuildItemType(sourceItems, itemType) {
+				continue
+			}
 			itemGroup := vcxproj.SubElement("ItemGroup")
-			for _, v := range clCompileSources {
-				item := itemGroup.SubElement("ClCompile", xmlAttr("Include", v.Include))
+			for _, v := range sourceItems {
+				if v.ItemType != itemType {
+					continue
+				}
+				item := itemGroup.SubElement(v.ItemType, xmlAttr("Include", v.Include))
 				for _, e := range v.ExcludedFromBuild {
 					item.SubElement("ExcludedFromBuild", xmlAttr("Condition", e.Condition)).SetText(fmt.Sprintf("%v", e.Excluded))
 				}
+				for _, m := range v.Metadata {
+					if len(m.Condition) > 0 {
+						item.SubElement(m.Name, xmlAttr("Condition", m.Condition)).SetText(m.Value)
+					} else {
+						item.SubElement(m.Name).SetText(m.Value)
+					}
+				}
 			}
 		}
 
@@ -411,6 +671,11 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 				s.SubElement("UniqueIdentifier").SetText("{93995380-89BD-4b04-88EB-625FBE52EBFB}")
 				s.SubElement("Extensions").SetText("h;hh;hpp;hxx;hm;inl;inc;xsd")
 			}
+			if hasMSBuildItemType(sourceItems, "ResourceCompile") {
+				s := itemGroup.SubElement("Filter", xmlAttr("Include", "Resource Files"))
+				s.SubElement("UniqueIdentifier").SetText("{67DA6AB6-F800-4c08-8B7A-83BB121AAD01}")
+				s.SubElement("Extensions").SetText("rc;ico;cur;bmp;dlg;rc2;rct;bin;rgs;gif;jpg;jpeg;jpe;resx;tiff;tif;png;wav")
+			}
 		}
 		{
 			itemGroup := filters.SubElement("ItemGroup")
@@ -419,11 +684,17 @@ func (generator *MSBuildGenerator) Generate(env *Environment, graph *Graph) {
 				s.SubElement("Filter").SetText("Graphics")
 			}
 		}
-		{
+		for _, itemType := range msbuildCompileItemTypes {
+			if itemType != "ClCompile" && !hasMSBuildItemType(sourceItems, itemType) {
+				continue
+			}
 			itemGroup := filters.SubElement("ItemGroup")
-			for _, s := range clCompileSources {
-				s := itemGroup.SubElement("ClCompile", xmlAttr("Include", s.Include))
-				s.SubElement("Filter").SetText("Graphics")
+			for _, s := range sourceItems {
+				if s.ItemType != itemType {
+					continue
+				}
+				element := itemGroup.SubElement(s.ItemType, xmlAttr("Include", s.Include))
+				element.SubElement("Filter").SetText(getMSBuildItemFilter(s.ItemType))
 			}
 		}
 	}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetSourceSettingsMetadata(t *testing.T) {
+	node := &Node{
+		Name: "engine",
+		SourceSettings: []SourceSettings{
+			{Glob: "src/simd/*", Defines: []string{"SIMD=1"}, CompilerFlags: []string{"/arch:AVX2"}},
+		},
+		Tagged: map[string]*Node{
+			"debug": &Node{
+				SourceSettings: []SourceSettings{
+					{Glob: "src/simd/*.cpp", IncludeDirs: []string{"src/simd/debug"}, Defines: []string{"SIMD_CHECKS=1"}},
+				},
+			},
+		},
+	}
+	env := &Environment{OutDir: "out", Tags: []string{"debug"}}
+	condition := "'$(Configuration)|$(Platform)'=='Debug|x64'"
+
+	actual := getSourceSettingsMetadata(node, env, "src/simd/b.cpp", condition)
+	expected := []MSBuildXMLMetadata{
+		{Name: "AdditionalIncludeDirectories", Condition: condition, Value: "../src/simd/debug;%(AdditionalIncludeDirectories)"},
+		{Name: "PreprocessorDefinitions", Condition: condition, Value: "SIMD=1;SIMD_CHECKS=1;%(PreprocessorDefinitions)"},
+		{Name: "AdditionalOptions", Condition: condition, Value: "/arch:AVX2 %(AdditionalOptions)"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected metadata:\n%v", actual)
+	}
+
+	if actual := getSourceSettingsMetadata(node, env, "src/a.cpp", condition); len(actual) != 0 {
+		t.Errorf("Unexpected metadata:\n%v", actual)
+	}
+}
+
+func TestGetMSBuildItemType(t *testing.T) {
+	for fileType, expected := range map[SourceFileType]string{
+		SourceFileTypeCSource:   "ClCompile",
+		SourceFileTypeCppSource: "ClCompile",
+		SourceFileTypeCppHeader: "ClInclude",
+		SourceFileTypeResource:  "ResourceCompile",
+		SourceFileTypeNasm:      "None",
+		SourceFileTypeObjC:      "None",
+	} {
+		if actual := getMSBuildItemType(fileType); actual != expected {
+			t.Errorf("Unexpected item type of %v: %s", fileType, actual)
+		}
+	}
+}
+
+func TestExpandActionCommand(t *testing.T) {
+	actual := expandActionCommand("$host_bin_dir/gen --tools $bin_dir $in -o $out", []string{"a.txt", "b.txt"}, []string{"a.h"})
+	expected := `$(OutDir.TrimEnd('\'))/gen --tools $(OutDir.TrimEnd('\')) a.txt b.txt -o a.h`
+	if actual != expected {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+}
+
+func TestSetMSBuildCompilerLauncher(t *testing.T) {
+	for launcher, expected := range map[string]map[string]string{
+		"clcache":                 {"CLToolExe": "clcache"},
+		"C:/tools/clcache.exe":    {"CLToolExe": "clcache.exe", "CLToolPath": "C:/tools"},
+		"ccache":                  {},
+		"sccache":                 {},
+		"C:/tools/sccache.exe -v": {},
+	} {
+		general := map[string]string{}
+		setMSBuildCompilerLauncher(general, launcher)
+		if !reflect.DeepEqual(general, expected) {
+			t.Errorf("Unexpected settings for %s: %v", launcher, general)
+		}
+	}
+}
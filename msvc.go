@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// msvcDepsPrefix is the prefix of the lines that cl.exe prints for /showIncludes.
+const msvcDepsPrefix = "Note: including file:"
+
+func isMSVC(env *Environment) bool {
+	return getEnvironmentToolchain(env).Flavor == toolchainFlavorMSVC
+}
+
+// quoteWindowsArg quotes the argument for the command line parser of the MSVC runtime.
+func quoteWindowsArg(arg string) string {
+	if len(arg) > 0 && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch c {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// NOTE: The backslashes before a quote are escaped as well as the quote.
+			buf.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			buf.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		buf.WriteByte(c)
+	}
+	buf.WriteString(strings.Repeat(`\`, backslashes*2))
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// joinMSVCOptions joins the options with the prefix such as "/I" and "/D".
+func joinMSVCOptions(prefix string, options []string) string {
+	quoted := make([]string, 0, len(options))
+	for _, option := range options {
+		quoted = append(quoted, quoteWindowsArg(prefix+option))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// msvcCompilerFlags maps the GCC-style compile flags to the ones of cl.exe.
+// The flags mapped to an empty string have no equivalent and are removed.
+var msvcCompilerFlags = map[string]string{
+	"-Wall":           "/W4",
+	"-Wextra":         "",
+	"-Werror":         "/WX",
+	"-w":              "/w",
+	"-O0":             "/Od",
+	"-O1":             "/O1",
+	"-O2":             "/O2",
+	"-O3":             "/Ox",
+	"-Os":             "/O1",
+	"-g":              "/Z7",
+	"-std=c99":        "",
+	"-std=c11":        "/std:c11",
+	"-std=c17":        "/std:c17",
+	"-std=c++11":      "",
+	"-std=c++14":      "/std:c++14",
+	"-std=c++17":      "/std:c++17",
+	"-std=c++20":      "/std:c++20",
+	"-fexceptions":    "/EHsc",
+	"-fno-exceptions": "/EHs-c-",
+	"-frtti":          "/GR",
+	"-fno-rtti":       "/GR-",
+	"-fPIC":           "",
+	"-pthread":        "",
+}
+
+// translateMSVCCompilerFlags translates the common GCC-style compile flags for cl.exe.
+// The other flags are passed through so that the manifests can specify MSVC flags.
+func translateMSVCCompilerFlags(flags []string) (result []string) {
+	for _, flag := range flags {
+		switch translated, ok := msvcCompilerFlags[flag]; {
+		case ok && len(translated) == 0:
+		case ok:
+			result = append(result, translated)
+		case strings.HasPrefix(flag, "-D") || strings.HasPrefix(flag, "-I"):
+			result = append(result, "/"+flag[1:])
+		default:
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+// translateMSVCLinkerFlags translates the common GCC-style link flags for link.exe.
+func translateMSVCLinkerFlags(flags []string) (result []string) {
+	for _, flag := range flags {
+		switch {
+		case flag == "-g":
+			result = append(result, "/DEBUG")
+		case flag == "-pthread" || strings.HasPrefix(flag, "-framework "):
+		case strings.HasPrefix(flag, "-L"):
+			result = append(result, quoteWindowsArg("/LIBPATH:"+flag[2:]))
+		case strings.HasPrefix(flag, "-l"):
+			result = append(result, quoteWindowsArg(flag[2:]+".lib"))
+		default:
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+// addMSVCRules adds the rules that run cl.exe, lib.exe and link.exe or the compatible tools.
+func (gen *NinjaGenerator) addMSVCRules(toolchain *Toolchain, suffix string) {
+	cc := toolchain.CC
+	cxx := toolchain.CXX
+	if len(toolchain.CompilerLauncher) > 0 {
+		cc = toolchain.CompilerLauncher + " " + cc
+		cxx = toolchain.CompilerLauncher + " " + cxx
+	}
+
+	gen.AddRule(&NinjaRule{
+		Name:    "compile_c" + suffix,
+		Command: cc + " /nologo /showIncludes $defines $include_dirs $cflags $cflags_c /c /Tc$in /Fo$out",
+		Deps:    "msvc",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "compile" + suffix,
+		Command: cxx + " /nologo /showIncludes /EHsc $defines $include_dirs $cflags $cflags_cc /c /Tp$in /Fo$out",
+		Deps:    "msvc",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "nasm" + suffix,
+		Command: toolchain.Nasm + " -MD $out.d $defines $include_dirs $nasmflags -o $out $in",
+		Deps:    "gcc",
+		DepFile: "$out.d",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "windres" + suffix,
+		Command: toolchain.Windres + " /nologo $defines $include_dirs $rcflags /fo $out $in",
+	})
+
+	if toolchain.UseResponseFiles {
+		gen.AddRule(&NinjaRule{
+			Name:           "link" + suffix,
+			Command:        toolchain.Linker + " /nologo @$out.rsp $ldflags /OUT:$out",
+			RspFile:        "$out.rsp",
+			RspFileContent: "$in_newline",
+		})
+		for _, name := range []string{"archive", "archive-static-libs"} {
+			gen.AddRule(&NinjaRule{
+				Name:           name + suffix,
+				Command:        toolchain.Archiver + " /nologo /OUT:$out @$out.rsp",
+				RspFile:        "$out.rsp",
+				RspFileContent: "$in_newline",
+			})
+		}
+		return
+	}
+
+	gen.AddRule(&NinjaRule{
+		Name:    "link" + suffix,
+		Command: toolchain.Linker + " /nologo $in $ldflags /OUT:$out",
+	})
+	// NOTE: lib.exe merges the static libraries in the inputs like libtool.
+	for _, name := range []string{"archive", "archive-static-libs"} {
+		gen.AddRule(&NinjaRule{
+			Name:    name + suffix,
+			Command: toolchain.Archiver + " /nologo /OUT:$out $in",
+		})
+	}
+}
+
+// getMSVCObjectExtension gets the extension of the object file compiled from the source.
+func getMSVCObjectExtension(source string) string {
+	if strings.EqualFold(filepath.Ext(source), ".rc") {
+		return ".res"
+	}
+	return ".obj"
+}
+
+// parseShowIncludes extracts the headers from the output of /showIncludes
+// and returns the other lines like ninja does for "deps = msvc".
+func parseShowIncludes(output []byte) (deps []string, rest []byte) {
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		if strings.HasPrefix(line, msvcDepsPrefix) {
+			deps = append(deps, strings.TrimSpace(strings.TrimPrefix(line, msvcDepsPrefix)))
+			continue
+		}
+		buf.WriteString(line)
+	}
+	return deps, buf.Bytes()
+}
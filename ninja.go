@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,8 +14,64 @@ import (
 // NinjaGenerator generates a ninja file.
 type NinjaGenerator struct {
 	Variables []string
+	Pools     []*NinjaPool
 	Rules     []*NinjaRule
 	Nodes     []*NinjaBuild
+	Defaults  []string
+	Files     []*GeneratedFile
+
+	outputs map[string]bool
+}
+
+// GeneratedFile represents a file that is written along with the ninja file.
+type GeneratedFile struct {
+	Path    string
+	Content string
+}
+
+const (
+	consolePoolName      = "console"
+	linkPoolName         = "link_pool"
+	defaultLinkPoolDepth = 4
+
+	// allTargetName is the phony target that builds the outputs of all targets.
+	allTargetName = "all"
+)
+
+// AddPool adds the new pool to the ninja definition.
+func (gen *NinjaGenerator) AddPool(pool *NinjaPool) {
+	gen.Pools = append(gen.Pools, pool)
+}
+
+// HasPool returns true if the pool is declared or built in.
+func (gen *NinjaGenerator) HasPool(name string) bool {
+	if name == consolePoolName {
+		return true
+	}
+	for _, pool := range gen.Pools {
+		if pool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (gen *NinjaGenerator) getRule(name string) *NinjaRule {
+	for _, rule := range gen.Rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// getNodePool gets the pool of the target or "" if the target has no pool.
+// The pools that are not declared are rejected by validateGraph.
+func (gen *NinjaGenerator) getNodePool(env *Environment, node *Node) string {
+	if pool := node.GetPool(env); gen.HasPool(pool) {
+		return pool
+	}
+	return ""
 }
 
 // AddRule adds the new rule to the ninja definition.
@@ -24,6 +82,27 @@ func (gen *NinjaGenerator) AddRule(rule *NinjaRule) {
 // AddNode adds the build node to the ninja graph.
 func (gen *NinjaGenerator) AddNode(node *NinjaBuild) {
 	gen.Nodes = append(gen.Nodes, node)
+	if gen.outputs == nil {
+		gen.outputs = map[string]bool{}
+	}
+	for _, output := range node.Outputs {
+		gen.outputs[output] = true
+	}
+}
+
+// HasOutput returns true if the output is already built by a node.
+func (gen *NinjaGenerator) HasOutput(output string) bool {
+	return gen.outputs[output]
+}
+
+// AddFile adds the file that is written along with the ninja file.
+func (gen *NinjaGenerator) AddFile(path, content string) {
+	gen.Files = append(gen.Files, &GeneratedFile{Path: path, Content: content})
+}
+
+// AddDefault adds the targets that ninja builds when no targets are specified.
+func (gen *NinjaGenerator) AddDefault(targets ...string) {
+	gen.Defaults = append(gen.Defaults, targets...)
 }
 
 // AddVariable adds the new variable to the ninja definition.
@@ -31,42 +110,101 @@ func (gen *NinjaGenerator) AddVariable(key, value string) {
 	gen.Variables = append(gen.Variables, key+" = "+value)
 }
 
+// joinNinjaOptions joins the options with the prefix and quotes them for the shell.
 func joinNinjaOptions(prefix string, options []string) string {
 	str := ""
 	for i, d := range options {
 		if i > 0 {
 			str += " "
 		}
-		str += prefix
-		str += d
+		str += quoteShellArg(prefix + d)
 	}
 	return str
 }
 
-func compileSources(env *Environment, node *Node, generator *NinjaGenerator) (objFiles []string) {
+// getObjectFile gets the object path for the source. Objects are placed
+// in the directory of each target so that sources with the same name do not collide.
+func getObjectFile(env *Environment, node *Node, source string) string {
+	ext := ".o"
+	if isMSVC(env) {
+		ext = getMSVCObjectExtension(source)
+	}
+	source = strings.Replace(filepath.ToSlash(filepath.Clean(source)), "../", "__/", -1)
+	return filepath.Join(env.OutDir, "obj", node.Name, filepath.FromSlash(source)+ext)
+}
+
+// getFeatureFlags gets the compile and link flags of the features enabled in the environment.
+// The flags are applied to all targets so that the objects and the executables match.
+func getFeatureFlags(env *Environment) (cflags, ldflags []string) {
+	for _, feature := range env.FeatureFlags {
+		cflags = append(cflags, feature.CompilerFlags...)
+		ldflags = append(ldflags, feature.LinkerFlags...)
+	}
+	return cflags, ldflags
+}
+
+func compileSources(env *Environment, fileTypes SourceFileTypes, node *Node, generator *NinjaGenerator) (objFiles []string) {
 	sources := node.GetSources(env)
-	includeDirs := node.GetIncludeDirs(env)
-	defines := node.GetDefines(env)
+	includeDirs := append(node.GetIncludeDirs(env), getPrebuiltIncludeDirs(env, node)...)
+	defines := append(node.GetDefines(env), getPrebuiltDefines(env, node)...)
 
-	cflags := node.GetCompilerFlags(env)
+	platformCFlags, _ := getPlatformFlags(env)
+	featureCFlags, _ := getFeatureFlags(env)
+	cflags := append(append(platformCFlags, featureCFlags...), node.GetCompilerFlags(env)...)
+	cflags = append(cflags, getExternalCompilerFlags(env, node)...)
 	cflagsC := node.GetCompilerFlagsC(env)
 	cflagsCC := node.GetCompilerFlagsCC(env)
+	cflagsObjC := node.GetCompilerFlagsObjC(env)
+	cflagsObjCC := node.GetCompilerFlagsObjCC(env)
+	asmflags := node.GetAssemblerFlags(env)
+	nasmflags := node.GetNasmFlags(env)
+	rcflags := node.GetResourceFlags(env)
+	if isMSVC(env) {
+		cflagsC = translateMSVCCompilerFlags(cflagsC)
+		cflagsCC = translateMSVCCompilerFlags(cflagsCC)
+	}
+
+	// NOTE: Generated headers must exist before compiling the sources that include them.
+	generatedFiles := getActionOutputs(env, node)
 
 	for _, source := range sources {
-		sourceFileType := getSourceFileType(source)
+		sourceFileType := fileTypes.Get(source)
 
-		obj := filepath.Clean(filepath.Join(env.OutDir, "obj", source+".o"))
-		objFiles = append(objFiles, obj)
+		obj := getObjectFile(env, node, source)
 
-		variables := map[string]string{}
-		if len(includeDirs) > 0 {
-			variables["include_dirs"] = joinNinjaOptions("-I", includeDirs)
+		sourceIncludeDirs := append([]string{}, includeDirs...)
+		sourceDefines := append([]string{}, defines...)
+		sourceCFlags := append([]string{}, cflags...)
+		for _, settings := range node.GetSourceSettings(env, source) {
+			sourceIncludeDirs = append(sourceIncludeDirs, settings.IncludeDirs...)
+			sourceDefines = append(sourceDefines, settings.Defines...)
+			sourceCFlags = append(sourceCFlags, settings.CompilerFlags...)
 		}
-		if len(defines) > 0 {
-			variables["defines"] = joinNinjaOptions("-D", defines)
+
+		variables := map[string]string{}
+		if isMSVC(env) {
+			// NOTE: NASM takes the GCC-style options even with MSVC.
+			includePrefix, definePrefix := "/I", "/D"
+			if sourceFileType == SourceFileTypeNasm {
+				includePrefix, definePrefix = "-I", "-D"
+			}
+			if len(sourceIncludeDirs) > 0 {
+				variables["include_dirs"] = joinMSVCOptions(includePrefix, sourceIncludeDirs)
+			}
+			if len(sourceDefines) > 0 {
+				variables["defines"] = joinMSVCOptions(definePrefix, sourceDefines)
+			}
+			sourceCFlags = translateMSVCCompilerFlags(sourceCFlags)
+		} else {
+			if len(sourceIncludeDirs) > 0 {
+				variables["include_dirs"] = joinNinjaOptions("-I", sourceIncludeDirs)
+			}
+			if len(sourceDefines) > 0 {
+				variables["defines"] = joinNinjaOptions("-D", sourceDefines)
+			}
 		}
 
-		variables["cflags"] = strings.Join(cflags, " ")
+		variables["cflags"] = strings.Join(sourceCFlags, " ")
 
 		var compileRule string
 		switch sourceFileType {
@@ -76,100 +214,520 @@ func compileSources(env *Environment, node *Node, generator *NinjaGenerator) (ob
 		case SourceFileTypeCppSource:
 			compileRule = "compile"
 			variables["cflags_cc"] = strings.Join(cflagsCC, " ")
+		case SourceFileTypeObjC:
+			compileRule = "compile_objc"
+			variables["cflags_objc"] = strings.Join(cflagsObjC, " ")
+		case SourceFileTypeObjCpp:
+			compileRule = "compile_objcxx"
+			variables["cflags_objcc"] = strings.Join(cflagsObjCC, " ")
+		case SourceFileTypeAsm:
+			compileRule = "assemble"
+			variables["asmflags"] = strings.Join(asmflags, " ")
+		case SourceFileTypeAsmCpp:
+			compileRule = "assemble_cpp"
+			variables["asmflags"] = strings.Join(asmflags, " ")
+		case SourceFileTypeNasm:
+			compileRule = "nasm"
+			variables["nasmflags"] = strings.Join(nasmflags, " ")
+		case SourceFileTypeResource:
+			compileRule = "windres"
+			variables["rcflags"] = strings.Join(rcflags, " ")
 		default:
 			continue
 		}
+		if generator.getRule(compileRule+env.RuleSuffix) == nil {
+			fmt.Printf("warning: %s: Toolchain \"%s\" cannot compile \"%s\"\n", node.Name, getEnvironmentToolchain(env).Name, source)
+			continue
+		}
 
+		objFiles = append(objFiles, obj)
 		generator.AddNode(&NinjaBuild{
-			Rule:      compileRule,
-			Inputs:    []string{source},
-			Outputs:   []string{obj},
-			Variables: variables,
+			Rule:          compileRule + env.RuleSuffix,
+			Inputs:        []string{source},
+			Outputs:       []string{obj},
+			OrderOnlyDeps: removeStringFromSlice(generatedFiles, source),
+			Variables:     variables,
+			Pool:          generator.getNodePool(env, node),
 		})
 	}
 	return objFiles
 }
 
+// getLibDirFlag gets the flag that adds the directory to the library search paths.
+func getLibDirFlag(env *Environment, dir string) string {
+	if isMSVC(env) {
+		return quoteWindowsArg("/LIBPATH:" + dir)
+	}
+	return quoteShellArg("-L" + dir)
+}
+
+func getExecutableFile(env *Environment, node *Node) string {
+	switch {
+	case isEmscripten(env):
+		return filepath.Join(env.OutDir, "bin", node.Name+".js")
+	case isMSVC(env):
+		return filepath.Join(env.OutDir, "bin", node.Name+".exe")
+	}
+	return filepath.Join(env.OutDir, "bin", node.Name)
+}
+
+func getStaticLibraryFile(env *Environment, node *Node) string {
+	if isMSVC(env) {
+		return filepath.Join(env.OutDir, "bin", node.Name+".lib")
+	}
+	return filepath.Join(env.OutDir, "bin", "lib"+node.Name+".a")
+}
+
+func removeStringFromSlice(in []string, s string) []string {
+	results := make([]string, 0, len(in))
+	for _, v := range in {
+		if v != s {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+func getActionOutputs(env *Environment, node *Node) (result []string) {
+	for _, action := range node.GetActions(env) {
+		result = append(result, action.Outputs...)
+	}
+	for _, dep := range node.Dependencies {
+		if dep.Type == OutputTypeAction {
+			result = append(result, getActionOutputs(env, dep)...)
+		}
+	}
+	return result
+}
+
+func generateActions(env *Environment, node *Node, generator *NinjaGenerator) {
+	toolFiles := getActionTools(env, node)
+	hostEnv := env
+	if env.Host != nil {
+		hostEnv = env.Host
+	}
+	variables := map[string]string{
+		"bin_dir":      quoteShellArg(filepath.Join(env.OutDir, "bin")),
+		"host_bin_dir": quoteShellArg(filepath.Join(hostEnv.OutDir, "bin")),
+	}
+
+	for i, action := range node.GetActions(env) {
+		if len(action.Outputs) > 0 && generator.HasOutput(action.Outputs[0]) {
+			// NOTE: The outputs of actions are not placed in the output directory,
+			// so configurations in the same ninja file share them.
+			continue
+		}
+		name := fmt.Sprintf("action_%s_%d", node.Name, i)
+		if len(env.ConfigName) > 0 {
+			name = fmt.Sprintf("action_%s_%s_%d", env.ConfigName, node.Name, i)
+		}
+		rule := &NinjaRule{
+			Name:        name + env.RuleSuffix,
+			Command:     action.Command,
+			Description: action.Description,
+			DepFile:     action.DepFile,
+		}
+		generator.AddRule(rule)
+		generator.AddNode(&NinjaBuild{
+			Rule:         rule.Name,
+			Inputs:       action.Inputs,
+			ImplicitDeps: toolFiles,
+			Outputs:      action.Outputs,
+			Variables:    variables,
+			Pool:         generator.getNodePool(env, node),
+		})
+	}
+}
+
+// getActionTools gets the executables that the actions of the node depend on.
+// The actions can run them from "$bin_dir" and the host targets from "$host_bin_dir".
+func getActionTools(env *Environment, node *Node) (result []string) {
+	for _, dep := range node.Dependencies {
+		if dep.Type == OutputTypeExecutable {
+			result = append(result, getExecutableFile(getNodeEnvironment(env, dep), dep))
+		}
+	}
+	return result
+}
+
+func getLinkFrameworks(env *Environment, node *Node) (result []string) {
+	result = append(result, node.GetFrameworks(env)...)
+	for _, dep := range node.Dependencies {
+		// NOTE: Static libraries cannot carry frameworks, so the executable links them instead.
+		result = append(result, getLinkFrameworks(env, dep)...)
+	}
+	return removeDuplicatesFromSlice(result)
+}
+
 // Generate generates the ninja definitions from　graph contains the intermediate nodes.
 func (gen *NinjaGenerator) Generate(env *Environment, graph *Graph) {
+	gen.addBuiltinRules(getEnvironmentToolchain(env), env.RuleSuffix)
+	if env.Host != nil && hasHostNodes(graph.Nodes) {
+		gen.addBuiltinRules(getEnvironmentToolchain(env.Host), env.Host.RuleSuffix)
+	}
+	gen.addPools(graph)
+	gen.generateNodes(env, graph)
+
+	outputs, defaults := gen.generateAliases(env, graph, "")
+	gen.addPhony(allTargetName, outputs)
+	gen.AddDefault(defaults...)
+	gen.generateInstall(env, graph)
+}
+
+// GenerateConfigs generates the ninja definitions for several configurations.
+// The outputs of each configuration can be built by "<config>:<target>".
+// All configurations must use the same toolchain and the same host toolchain
+// since they share the rules.
+func (gen *NinjaGenerator) GenerateConfigs(envs []*Environment, graph *Graph) {
+	if len(envs) == 0 {
+		return
+	}
+	gen.addBuiltinRules(getEnvironmentToolchain(envs[0]), envs[0].RuleSuffix)
+	for _, env := range envs {
+		if env.Host != nil && hasHostNodes(graph.Nodes) {
+			gen.addBuiltinRules(getEnvironmentToolchain(env.Host), env.Host.RuleSuffix)
+			break
+		}
+	}
+	gen.addPools(graph)
+
+	allOutputs := []string{}
+	for i, env := range envs {
+		gen.generateNodes(env, graph)
+
+		outputs, defaults := gen.generateAliases(env, graph, env.ConfigName+":")
+		gen.addPhony(env.ConfigName, outputs)
+		allOutputs = append(allOutputs, outputs...)
+
+		if i == 0 {
+			// NOTE: The first configuration is the default one.
+			gen.generateAliases(env, graph, "")
+			gen.AddDefault(defaults...)
+			gen.generateInstall(env, graph)
+		}
+	}
+	gen.addPhony(allTargetName, allOutputs)
+}
+
+func (gen *NinjaGenerator) addPhony(name string, inputs []string) {
+	if len(inputs) == 0 {
+		return
+	}
+	if gen.HasOutput(name) {
+		fmt.Printf("warning: Phony target \"%s\" is not generated because \"%s\" is already an output\n", name, name)
+		return
+	}
+	gen.AddNode(&NinjaBuild{
+		Rule:    "phony",
+		Inputs:  inputs,
+		Outputs: []string{name},
+	})
+}
+
+// generateAliases adds phony targets named after the targets in the manifests.
+// It returns the outputs of all targets and the aliases of the root targets.
+func (gen *NinjaGenerator) generateAliases(env *Environment, graph *Graph, prefix string) (outputs, defaults []string) {
+	sources := map[*Node]bool{}
+	for _, node := range graph.Sources {
+		sources[node] = true
+	}
+
+	envs := getNodeEnvironments(env, graph.Nodes)
+	for _, node := range graph.Nodes {
+		nodeOutputs := getNodeOutputs(envs[node], node)
+		if len(nodeOutputs) == 0 {
+			continue
+		}
+		outputs = append(outputs, nodeOutputs...)
+
+		alias := prefix + node.Name
+		gen.addPhony(alias, nodeOutputs)
+		if sources[node] {
+			defaults = append(defaults, alias)
+		}
+	}
+	return outputs, defaults
+}
+
+// addPools declares the pools in the manifests and assigns them to the rules.
+// The link rule runs in the built-in link_pool unless the manifests assign another pool.
+func (gen *NinjaGenerator) addPools(graph *Graph) {
+	if !hasPool(graph.Pools, linkPoolName) {
+		gen.AddPool(&NinjaPool{Name: linkPoolName, Depth: defaultLinkPoolDepth})
+	}
+	for _, rule := range gen.getRules("link") {
+		rule.Pool = linkPoolName
+	}
+
+	for _, pool := range graph.Pools {
+		gen.AddPool(&NinjaPool{Name: pool.Name, Depth: pool.Depth})
+		for _, name := range pool.Rules {
+			rules := gen.getRules(name)
+			if len(rules) == 0 {
+				fmt.Printf("warning: Unknown rule \"%s\" in pool \"%s\"\n", name, pool.Name)
+				continue
+			}
+			for _, rule := range rules {
+				rule.Pool = pool.Name
+			}
+		}
+	}
+}
+
+// getRules gets the built-in rule and its variant for the host toolchain.
+func (gen *NinjaGenerator) getRules(name string) (result []*NinjaRule) {
+	for _, ruleName := range []string{name, name + hostRuleSuffix} {
+		if rule := gen.getRule(ruleName); rule != nil {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+func getEnvironmentToolchain(env *Environment) *Toolchain {
+	if env.Toolchain != nil {
+		return env.Toolchain
+	}
+	return getBuiltinToolchain(defaultToolchainName)
+}
+
+// addBuiltinRules adds the rules that run the toolchain. The suffix is appended
+// to the names of the rules so that several toolchains can be used in one ninja file.
+func (gen *NinjaGenerator) addBuiltinRules(toolchain *Toolchain, suffix string) {
+	if toolchain.Flavor == toolchainFlavorMSVC {
+		gen.addMSVCRules(toolchain, suffix)
+		return
+	}
+
+	cc := toolchain.CC
+	cxx := toolchain.CXX
+	if len(toolchain.CompilerLauncher) > 0 {
+		cc = toolchain.CompilerLauncher + " " + cc
+		cxx = toolchain.CompilerLauncher + " " + cxx
+	}
+
 	// $cxx -MMD -MF $out.d $defines $includes $cflags $cflags_cc
 	gen.AddRule(&NinjaRule{
-		Name:    "compile_c",
-		Command: "clang -MMD -MF $out.d $defines $include_dirs $cflags $cflags_c -c $in -o $out",
+		Name:    "compile_c" + suffix,
+		Command: cc + " -MMD -MF $out.d $defines $include_dirs $cflags $cflags_c -c $in -o $out",
+		Deps:    "gcc",
+		DepFile: "$out.d",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "compile" + suffix,
+		Command: cxx + " -MMD -MF $out.d $defines $include_dirs $cflags $cflags_cc -c $in -o $out",
+		Deps:    "gcc",
+		DepFile: "$out.d",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "compile_objc" + suffix,
+		Command: cc + " -MMD -MF $out.d $defines $include_dirs $cflags $cflags_objc -c $in -o $out",
+		Deps:    "gcc",
+		DepFile: "$out.d",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "compile_objcxx" + suffix,
+		Command: cxx + " -MMD -MF $out.d $defines $include_dirs $cflags $cflags_objcc -c $in -o $out",
 		Deps:    "gcc",
 		DepFile: "$out.d",
 	})
 	gen.AddRule(&NinjaRule{
-		Name:    "compile",
-		Command: "clang++ -MMD -MF $out.d $defines $include_dirs $cflags $cflags_cc -c $in -o $out",
+		Name:    "assemble" + suffix,
+		Command: toolchain.CC + " $asmflags -c $in -o $out",
+	})
+	gen.AddRule(&NinjaRule{
+		Name:    "assemble_cpp" + suffix,
+		Command: toolchain.CC + " -MMD -MF $out.d $defines $include_dirs $asmflags -c $in -o $out",
 		Deps:    "gcc",
 		DepFile: "$out.d",
 	})
 	gen.AddRule(&NinjaRule{
-		Name:    "link",
-		Command: "ld $in $ldflags -o $out",
+		Name:    "nasm" + suffix,
+		Command: toolchain.Nasm + " -MD $out.d $defines $include_dirs $nasmflags -o $out $in",
+		Deps:    "gcc",
+		DepFile: "$out.d",
 	})
 	gen.AddRule(&NinjaRule{
-		Name:    "archive",
-		Command: "ar -rc $out $in",
+		Name:    "windres" + suffix,
+		Command: toolchain.Windres + " $defines $include_dirs $rcflags -O coff -i $in -o $out",
 	})
+
+	if toolchain.UseResponseFiles {
+		gen.AddRule(&NinjaRule{
+			Name:           "link" + suffix,
+			Command:        toolchain.Linker + " @$out.rsp $ldflags -o $out",
+			RspFile:        "$out.rsp",
+			RspFileContent: "$in",
+		})
+		gen.AddRule(&NinjaRule{
+			Name:           "archive" + suffix,
+			Command:        toolchain.Archiver + " -rc $out @$out.rsp",
+			RspFile:        "$out.rsp",
+			RspFileContent: "$in",
+		})
+		if toolchain.Flavor == toolchainFlavorEmscripten {
+			gen.AddRule(&NinjaRule{
+				Name:           "archive-static-libs" + suffix,
+				Command:        "rm -f $out && " + toolchain.Archiver + " qcsL $out @$out.rsp",
+				RspFile:        "$out.rsp",
+				RspFileContent: "$in",
+			})
+			return
+		}
+		// NOTE: libtool does not accept response files but reads a list of files separated by newlines.
+		gen.AddRule(&NinjaRule{
+			Name:           "archive-static-libs" + suffix,
+			Command:        toolchain.Libtool + " -static -o $out -filelist $out.rsp",
+			RspFile:        "$out.rsp",
+			RspFileContent: "$in_newline",
+		})
+		return
+	}
+
 	gen.AddRule(&NinjaRule{
-		Name:    "archive-static-libs",
-		Command: "libtool -static -o $out $in",
+		Name:    "link" + suffix,
+		Command: toolchain.Linker + " $in $ldflags -o $out",
 	})
+	gen.AddRule(&NinjaRule{
+		Name:    "archive" + suffix,
+		Command: toolchain.Archiver + " -rc $out $in",
+	})
+	if toolchain.Flavor == toolchainFlavorEmscripten {
+		// NOTE: emar cannot merge archives like libtool, so the L modifier adds the members of the input archives.
+		gen.AddRule(&NinjaRule{
+			Name:    "archive-static-libs" + suffix,
+			Command: "rm -f $out && " + toolchain.Archiver + " qcsL $out $in",
+		})
+		return
+	}
+	gen.AddRule(&NinjaRule{
+		Name:    "archive-static-libs" + suffix,
+		Command: toolchain.Libtool + " -static -o $out $in",
+	})
+}
+
+// generateNodes generates the nodes for the environment. When cross compiling,
+// the host targets and their dependencies are generated for the host environment.
+func (gen *NinjaGenerator) generateNodes(env *Environment, graph *Graph) {
+	if env.Host == nil {
+		gen.generateNodeList(env, graph, graph.Nodes)
+		return
+	}
+	targetNodes, hostNodes := splitHostNodes(graph.Nodes)
+	gen.generateNodeList(env, graph, targetNodes)
+	gen.generateNodeList(env.Host, graph, hostNodes)
+}
+
+func (gen *NinjaGenerator) generateNodeList(env *Environment, graph *Graph, nodes []*Node) {
+	for _, node := range nodes {
+		if node.Type != OutputTypeUnknown {
+			generateActions(env, node, gen)
+		}
 
-	for _, node := range graph.Nodes {
 		switch node.Type {
-		case OutputTypeExecutable:
-			objFiles := compileSources(env, node, gen)
+		case OutputTypeExecutable, OutputTypeTest:
+			objFiles := compileSources(env, graph.FileTypes, node, gen)
 			libraryFiles := []string{}
 			ldflags := []string{
-				"-L" + filepath.Join(env.OutDir, "bin"),
+				getLibDirFlag(env, filepath.Join(env.OutDir, "bin")),
 			}
-			for _, f := range node.GetLinkerFlags(env) {
-				ldflags = append(ldflags, f)
+			if isMSVC(env) {
+				ldflags = append(ldflags, translateMSVCLinkerFlags(node.GetLinkerFlags(env))...)
+			} else {
+				ldflags = append(ldflags, node.GetLinkerFlags(env)...)
 			}
+			_, platformLDFlags := getPlatformFlags(env)
+			ldflags = append(ldflags, platformLDFlags...)
+			_, featureLDFlags := getFeatureFlags(env)
+			ldflags = append(ldflags, featureLDFlags...)
 			for _, dir := range node.GetLibDirs(env) {
-				ldflags = append(ldflags, "-L"+dir)
+				ldflags = append(ldflags, getLibDirFlag(env, dir))
+			}
+			if !isMSVC(env) {
+				for _, framework := range getLinkFrameworks(env, node) {
+					ldflags = append(ldflags, "-framework "+framework)
+				}
 			}
 			for _, dep := range node.Dependencies {
 				switch dep.Type {
 				case OutputTypeStaticLibrary:
-					lib := filepath.Join(env.OutDir, "bin", "lib"+dep.Name+".a")
+					lib := getStaticLibraryFile(env, dep)
 					libraryFiles = append(libraryFiles, lib)
-					ldflags = append(ldflags, "-l"+dep.Name)
+					if isMSVC(env) {
+						ldflags = append(ldflags, quoteWindowsArg(filepath.Base(lib)))
+					} else {
+						ldflags = append(ldflags, "-l"+dep.Name)
+					}
+				}
+			}
+			// NOTE: The prebuilt libraries follow the static libraries that may use them.
+			for _, lib := range getPrebuiltLibraries(env, node) {
+				libraryFiles = append(libraryFiles, lib)
+				if isMSVC(env) {
+					ldflags = append(ldflags, quoteWindowsArg(lib))
+				} else {
+					ldflags = append(ldflags, quoteShellArg(lib))
 				}
 			}
-			executableFile := filepath.Join(env.OutDir, "bin", node.Name)
+			ldflags = append(ldflags, getExternalLinkerFlags(env, node)...)
+			executableFile := getExecutableFile(env, node)
+			implicitOuts := []string{}
+			if isEmscripten(env) {
+				settings := node.GetEmscriptenSettings(env)
+				ldflags = append(ldflags, getEmscriptenLinkerFlags(&settings)...)
+				libraryFiles = append(libraryFiles, getEmscriptenPreloadFiles(&settings)...)
+				implicitOuts = getEmscriptenOutputs(executableFile, &settings)
+			}
 			gen.AddNode(&NinjaBuild{
-				Rule:         "link",
+				Rule:         "link" + env.RuleSuffix,
 				Inputs:       objFiles,
 				ImplicitDeps: libraryFiles,
 				Outputs:      []string{executableFile},
+				ImplicitOuts: implicitOuts,
 				Variables: map[string]string{
 					"ldflags": strings.Join(ldflags, " "),
 				},
+				Pool: gen.getNodePool(env, node),
 			})
 		case OutputTypeStaticLibrary:
-			objFiles := compileSources(env, node, gen)
+			objFiles := compileSources(env, graph.FileTypes, node, gen)
 			libraryFiles := []string{}
 			for _, dep := range node.Dependencies {
 				switch dep.Type {
 				case OutputTypeStaticLibrary:
-					lib := filepath.Join(env.OutDir, "bin", "lib"+dep.Name+".a")
+					lib := getStaticLibraryFile(env, dep)
 					libraryFiles = append(libraryFiles, lib)
 				}
 			}
-			libFile := filepath.Join(env.OutDir, "bin", "lib"+node.Name+".a")
+			libFile := getStaticLibraryFile(env, node)
 			gen.AddNode(&NinjaBuild{
-				Rule:    "archive-static-libs",
+				Rule:    "archive-static-libs" + env.RuleSuffix,
 				Inputs:  append(objFiles, libraryFiles...),
 				Outputs: []string{libFile},
+				Pool:    gen.getNodePool(env, node),
 			})
 		}
 	}
 }
 
+// WriteGeneratedFiles writes the generated files. The files are rewritten only
+// when the contents are changed so that ninja does not copy them again.
+func (gen *NinjaGenerator) WriteGeneratedFiles() error {
+	for _, file := range gen.Files {
+		if content, err := ioutil.ReadFile(file.Path); err == nil && string(content) == file.Content {
+			continue
+		}
+		dir := filepath.Dir(file.Path)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "Failed to create output directory \"%s\"", dir)
+		}
+		if err := ioutil.WriteFile(file.Path, []byte(file.Content), 0644); err != nil {
+			return errors.Wrapf(err, "Failed to write \"%s\"", file.Path)
+		}
+	}
+	return nil
+}
+
 // WriteFile writes the ninja defintions to the specified file.
 func (gen *NinjaGenerator) WriteFile(ninjaFile string) error {
 	dir := filepath.Dir(ninjaFile)
@@ -198,6 +756,12 @@ func (gen *NinjaGenerator) WriteFile(ninjaFile string) error {
 		}
 	}
 
+	for _, pool := range gen.Pools {
+		if _, err := writer.WriteString(pool.ToString() + "\n"); err != nil {
+			return err
+		}
+	}
+
 	for i, rule := range gen.Rules {
 		if i > 0 {
 			if _, err := writer.WriteString("\n"); err != nil {
@@ -221,6 +785,16 @@ func (gen *NinjaGenerator) WriteFile(ninjaFile string) error {
 		}
 	}
 
+	if len(gen.Defaults) > 0 {
+		defaults := make([]string, 0, len(gen.Defaults))
+		for _, target := range gen.Defaults {
+			defaults = append(defaults, escapeNinjaPath(target))
+		}
+		if _, err := writer.WriteString("\ndefault " + strings.Join(defaults, " ") + "\n"); err != nil {
+			return err
+		}
+	}
+
 	writer.Flush()
 
 	return nil
@@ -3,33 +3,63 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // NinjaRule represents a rule for ninja.
 type NinjaRule struct {
-	Name        string
-	Command     string
-	Description string
-	Deps        string
-	DepFile     string
+	Name           string
+	Command        string
+	Description    string
+	Deps           string
+	DepFile        string
+	RspFile        string
+	RspFileContent string
+	Pool           string
+}
+
+// NinjaPool represents a pool for ninja.
+type NinjaPool struct {
+	Name  string
+	Depth int
 }
 
 // NinjaBuild represents a build statement for ninja.
 type NinjaBuild struct {
-	Rule         string
-	Outputs      []string
-	Inputs       []string
-	ImplicitOuts []string
-	ImplicitDeps []string
-	Variables    map[string]string
-	Pool         string
+	Rule          string
+	Outputs       []string
+	Inputs        []string
+	ImplicitOuts  []string
+	ImplicitDeps  []string
+	OrderOnlyDeps []string
+	Variables     map[string]string
+	Pool          string
+}
+
+var ninjaPathReplacer = strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:")
+
+// escapeNinjaPath escapes a path in build and default statements.
+func escapeNinjaPath(path string) string {
+	return ninjaPathReplacer.Replace(path)
+}
+
+// escapeNinjaValue escapes a variable value so that ninja reads it literally.
+func escapeNinjaValue(value string) string {
+	return strings.Replace(value, "$", "$$", -1)
+}
+
+// ToString converts a ninja pool to a string.
+func (p *NinjaPool) ToString() (str string) {
+	str += fmt.Sprintln("pool", p.Name)
+	str += fmt.Sprintln("  depth =", p.Depth)
+	return str
 }
 
 // ToString converts a ninja rule to a string.
 func (r *NinjaRule) ToString() (str string) {
 	str += fmt.Sprintln("rule", r.Name)
 	if len(r.Description) > 0 {
-		str += fmt.Sprintln("  description =", r.DepFile)
+		str += fmt.Sprintln("  description =", r.Description)
 	}
 	str += fmt.Sprintln("  command =", r.Command)
 	if len(r.Deps) > 0 {
@@ -38,12 +68,19 @@ func (r *NinjaRule) ToString() (str string) {
 	if len(r.DepFile) > 0 {
 		str += fmt.Sprintln("  depfile =", r.DepFile)
 	}
+	if len(r.RspFile) > 0 {
+		str += fmt.Sprintln("  rspfile =", r.RspFile)
+		str += fmt.Sprintln("  rspfile_content =", r.RspFileContent)
+	}
+	if len(r.Pool) > 0 {
+		str += fmt.Sprintln("  pool =", r.Pool)
+	}
 	return str
 }
 
 // ToString converts a ninja definition to a string.
 func (e *NinjaBuild) ToString() (str string) {
-	useMultiLine := (len(e.Outputs)+len(e.ImplicitOuts) > 1) || (len(e.Inputs)+len(e.ImplicitDeps) > 1)
+	useMultiLine := (len(e.Outputs)+len(e.ImplicitOuts) > 1) || (len(e.Inputs)+len(e.ImplicitDeps)+len(e.OrderOnlyDeps) > 1)
 
 	str += "build"
 	if len(e.Outputs) > 0 {
@@ -57,7 +94,7 @@ func (e *NinjaBuild) ToString() (str string) {
 		if i > 0 {
 			str += " $\n  "
 		}
-		str += f
+		str += escapeNinjaPath(f)
 	}
 	if len(e.ImplicitOuts) > 0 {
 		str += " | "
@@ -69,7 +106,7 @@ func (e *NinjaBuild) ToString() (str string) {
 		if i > 0 {
 			str += " $\n  "
 		}
-		str += f
+		str += escapeNinjaPath(f)
 	}
 	str += ": "
 	str += e.Rule
@@ -84,7 +121,7 @@ func (e *NinjaBuild) ToString() (str string) {
 		if i > 0 {
 			str += " $\n  "
 		}
-		str += f
+		str += escapeNinjaPath(f)
 	}
 	if len(e.ImplicitDeps) > 0 {
 		str += " | "
@@ -96,7 +133,19 @@ func (e *NinjaBuild) ToString() (str string) {
 		if i > 0 {
 			str += " $\n  "
 		}
-		str += f
+		str += escapeNinjaPath(f)
+	}
+	if len(e.OrderOnlyDeps) > 0 {
+		str += " || "
+		if useMultiLine {
+			str += "$\n  "
+		}
+	}
+	for i, f := range e.OrderOnlyDeps {
+		if i > 0 {
+			str += " $\n  "
+		}
+		str += escapeNinjaPath(f)
 	}
 	str += "\n"
 	if len(e.Pool) > 0 {
@@ -104,7 +153,7 @@ func (e *NinjaBuild) ToString() (str string) {
 	}
 	var variables []string
 	for k, v := range e.Variables {
-		variables = append(variables, fmt.Sprintf("  %s = %s\n", k, v))
+		variables = append(variables, fmt.Sprintf("  %s = %s\n", k, escapeNinjaValue(v)))
 	}
 	sort.Strings(variables)
 	for _, v := range variables {
@@ -128,6 +128,23 @@ func TestToStringMultipleImplicitDeps(t *testing.T) {
 	}
 }
 
+func TestToStringOrderOnlyDeps(t *testing.T) {
+	s := NinjaBuild{
+		Outputs:       []string{"a"},
+		Rule:          "b",
+		Inputs:        []string{"c"},
+		OrderOnlyDeps: []string{"d"},
+	}
+	actual := s.ToString()
+	expected := `build a: b $
+  c || $
+  d
+`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
 func TestToStringPool(t *testing.T) {
 	s := NinjaBuild{
 		Outputs:      []string{"a"},
@@ -171,3 +188,81 @@ func TestToStringVariables(t *testing.T) {
 		t.Errorf("Unexpected string:\n%v", actual)
 	}
 }
+
+func TestToStringEscape(t *testing.T) {
+	s := NinjaBuild{
+		Outputs: []string{"C:/my dir/a.o"},
+		Rule:    "b",
+		Inputs:  []string{"$HOME/a.cpp"},
+		Variables: map[string]string{
+			"defines": `'-DVERSION="1.0"' -DPRICE=$5`,
+		},
+	}
+	actual := s.ToString()
+	expected := `build C$:/my$ dir/a.o: b $$HOME/a.cpp
+  defines = '-DVERSION="1.0"' -DPRICE=$$5
+`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
+func TestRuleToString(t *testing.T) {
+	r := NinjaRule{
+		Name:        "compile",
+		Command:     "clang++ -c $in -o $out",
+		Description: "CXX $out",
+		Deps:        "gcc",
+		DepFile:     "$out.d",
+	}
+	actual := r.ToString()
+	expected := `rule compile
+  description = CXX $out
+  command = clang++ -c $in -o $out
+  deps = gcc
+  depfile = $out.d
+`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
+func TestJoinNinjaOptions(t *testing.T) {
+	actual := joinNinjaOptions("-D", []string{"DEBUG=1", `VERSION="1.0"`})
+	expected := `-DDEBUG=1 '-DVERSION="1.0"'`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
+func TestRuleToStringRspFile(t *testing.T) {
+	r := NinjaRule{
+		Name:           "link",
+		Command:        "ld @$out.rsp -o $out",
+		RspFile:        "$out.rsp",
+		RspFileContent: "$in",
+	}
+	actual := r.ToString()
+	expected := `rule link
+  command = ld @$out.rsp -o $out
+  rspfile = $out.rsp
+  rspfile_content = $in
+`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
+
+func TestPoolToString(t *testing.T) {
+	p := NinjaPool{
+		Name:  "link_pool",
+		Depth: 4,
+	}
+	actual := p.ToString()
+	expected := `pool link_pool
+  depth = 4
+`
+	if actual != expected {
+		t.Errorf("Unexpected string:\n%v", actual)
+	}
+}
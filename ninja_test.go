@@ -0,0 +1,336 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateMSVCNinja(t *testing.T) {
+	graph, err := parseGraph("testdata/msvc/build.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Environment{
+		OutDir:    "out",
+		Toolchain: getBuiltinToolchain(msvcToolchainName),
+	}
+	generator := &NinjaGenerator{}
+	generator.Generate(env, graph)
+
+	checkGoldenNinja(t, generator, "testdata/msvc/build.ninja.golden")
+}
+
+func TestGeneratePrebuiltNinja(t *testing.T) {
+	graph, err := parseGraph("testdata/prebuilt/build.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := &NinjaGenerator{}
+	generator.Generate(&Environment{OutDir: "out", Tags: []string{"linux"}}, graph)
+	checkGoldenNinja(t, generator, "testdata/prebuilt/build.ninja.golden")
+}
+
+// checkGoldenNinja compares the ninja file with the golden file.
+func checkGoldenNinja(t *testing.T, generator *NinjaGenerator, goldenFile string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ninjaFile := filepath.Join(dir, "build.ninja")
+	if err := generator.WriteFile(ninjaFile); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadFile(ninjaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateGolden {
+		if err := ioutil.WriteFile(goldenFile, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("Unexpected ninja file (run \"go test -update\" to update):\n%s", actual)
+	}
+}
+
+func TestQuoteWindowsArg(t *testing.T) {
+	cases := map[string]string{
+		"/Iinclude":            "/Iinclude",
+		"/Iinclude dir":        `"/Iinclude dir"`,
+		`/DNAME="a b"`:         `"/DNAME=\"a b\""`,
+		`/Iinclude dir\`:       `"/Iinclude dir\\"`,
+		"":                     `""`,
+		`C:\Program Files\lib`: `"C:\Program Files\lib"`,
+	}
+	for arg, expected := range cases {
+		if actual := quoteWindowsArg(arg); actual != expected {
+			t.Errorf("quoteWindowsArg(%q): expected %s, got %s", arg, expected, actual)
+		}
+	}
+}
+
+func TestParseShowIncludes(t *testing.T) {
+	output := "main.cpp\r\nNote: including file: C:\\src\\app.h\r\nNote: including file:  C:\\src\\util.h\r\nmain.cpp(3): warning C4100\r\n"
+	deps, rest := parseShowIncludes([]byte(output))
+	if len(deps) != 2 || deps[0] != `C:\src\app.h` || deps[1] != `C:\src\util.h` {
+		t.Errorf("Unexpected deps: %v", deps)
+	}
+	if string(rest) != "main.cpp\r\nmain.cpp(3): warning C4100\r\n" {
+		t.Errorf("Unexpected output: %q", rest)
+	}
+}
+
+func TestCompileSourcesSourceSettings(t *testing.T) {
+	node := &Node{
+		Name:        "engine",
+		Type:        OutputTypeStaticLibrary,
+		Sources:     []string{"src/a.cpp", "src/simd/b.cpp", "src/simd/c.c"},
+		IncludeDirs: []string{"include"},
+		SourceSettings: []SourceSettings{
+			{Glob: "src/simd/*", Defines: []string{"SIMD=1"}, CompilerFlags: []string{"-msse4.2"}},
+		},
+		Tagged: map[string]*Node{
+			"linux": &Node{
+				SourceSettings: []SourceSettings{
+					{Glob: "src/simd/*.cpp", IncludeDirs: []string{"src/simd/linux"}},
+				},
+			},
+		},
+	}
+	graph := &Graph{Nodes: []*Node{node}, Sources: []*Node{node}}
+	env := &Environment{OutDir: "out", Tags: []string{"linux"}}
+
+	generator := &NinjaGenerator{}
+	generator.Generate(env, graph)
+
+	getVariables := func(source string) map[string]string {
+		for _, build := range generator.Nodes {
+			if len(build.Inputs) == 1 && build.Inputs[0] == source {
+				return build.Variables
+			}
+		}
+		t.Fatalf("No build statement compiles %s", source)
+		return nil
+	}
+
+	expected := map[string]map[string]string{
+		"src/a.cpp": {
+			"include_dirs": "-Iinclude",
+			"cflags":       "",
+			"cflags_cc":    "",
+		},
+		"src/simd/b.cpp": {
+			"include_dirs": "-Iinclude -Isrc/simd/linux",
+			"defines":      "-DSIMD=1",
+			"cflags":       "-msse4.2",
+			"cflags_cc":    "",
+		},
+		"src/simd/c.c": {
+			"include_dirs": "-Iinclude",
+			"defines":      "-DSIMD=1",
+			"cflags":       "-msse4.2",
+			"cflags_c":     "",
+		},
+	}
+	for source, variables := range expected {
+		if actual := getVariables(source); !reflect.DeepEqual(actual, variables) {
+			t.Errorf("Unexpected variables of %s: %v", source, actual)
+		}
+	}
+}
+
+func TestGenerateConfigsAliases(t *testing.T) {
+	lib := &Node{Name: "lib", Type: OutputTypeStaticLibrary, Sources: []string{"lib.cpp"}}
+	app := &Node{Name: "app", Type: OutputTypeExecutable, Sources: []string{"main.cpp"}, Dependencies: []*Node{lib}}
+	graph := &Graph{Nodes: []*Node{app, lib}, Sources: []*Node{app}}
+	envs := []*Environment{
+		&Environment{OutDir: "out/Debug", ConfigName: "Debug"},
+		&Environment{OutDir: "out/Release", ConfigName: "Release"},
+	}
+
+	generator := &NinjaGenerator{}
+	generator.GenerateConfigs(envs, graph)
+
+	phonies := map[string][]string{}
+	for _, build := range generator.Nodes {
+		if build.Rule == "phony" {
+			phonies[build.Outputs[0]] = build.Inputs
+		}
+	}
+	expected := map[string][]string{
+		"Debug:app":   {"out/Debug/bin/app"},
+		"Debug:lib":   {"out/Debug/bin/liblib.a"},
+		"Release:app": {"out/Release/bin/app"},
+		"Release:lib": {"out/Release/bin/liblib.a"},
+		"app":         {"out/Debug/bin/app"},
+		"lib":         {"out/Debug/bin/liblib.a"},
+		"Debug":       {"out/Debug/bin/app", "out/Debug/bin/liblib.a"},
+		"Release":     {"out/Release/bin/app", "out/Release/bin/liblib.a"},
+		"all":         {"out/Debug/bin/app", "out/Debug/bin/liblib.a", "out/Release/bin/app", "out/Release/bin/liblib.a"},
+	}
+	if !reflect.DeepEqual(phonies, expected) {
+		t.Errorf("Unexpected phony targets:\n%v", phonies)
+	}
+	if !reflect.DeepEqual(generator.Defaults, []string{"Debug:app"}) {
+		t.Errorf("Unexpected defaults: %v", generator.Defaults)
+	}
+}
+
+func TestAddPhonyCollision(t *testing.T) {
+	generator := &NinjaGenerator{}
+	generator.addPhony("app", []string{"out/bin/app"})
+	generator.addPhony("app", []string{"out/bin/app.js"})
+	if len(generator.Nodes) != 1 || !reflect.DeepEqual(generator.Nodes[0].Inputs, []string{"out/bin/app"}) {
+		t.Errorf("Unexpected phony targets: %v", generator.Nodes)
+	}
+}
+
+func TestAddBuiltinRulesCompilerLauncher(t *testing.T) {
+	for _, test := range []struct {
+		toolchain *Toolchain
+		rule      string
+		prefix    string
+	}{
+		{&Toolchain{Flavor: toolchainFlavorClang, CC: "clang", CXX: "clang++", CompilerLauncher: "ccache"}, "compile", "ccache clang++ "},
+		{&Toolchain{Flavor: toolchainFlavorClang, CC: "clang", CXX: "clang++", CompilerLauncher: "ccache"}, "compile_c", "ccache clang "},
+		{&Toolchain{Flavor: toolchainFlavorMSVC, CC: "cl", CXX: "cl", CompilerLauncher: "sccache"}, "compile", "sccache cl "},
+		{&Toolchain{Flavor: toolchainFlavorClang, CC: "clang", CXX: "clang++", Linker: "ld", CompilerLauncher: "ccache"}, "link", "ld "},
+	} {
+		generator := &NinjaGenerator{}
+		generator.addBuiltinRules(test.toolchain.withDefaults(), "")
+		rule := generator.getRule(test.rule)
+		if rule == nil || !strings.HasPrefix(rule.Command, test.prefix) {
+			t.Errorf("Unexpected rule %s: %+v", test.rule, rule)
+		}
+	}
+}
+
+func TestCompileSourcesObjC(t *testing.T) {
+	node := &Node{
+		Name:               "app",
+		Type:               OutputTypeExecutable,
+		Sources:            []string{"src/main.cpp", "src/window.m", "src/renderer.mm"},
+		CompilerFlagsObjC:  []string{"-fobjc-arc"},
+		CompilerFlagsObjCC: []string{"-fobjc-arc", "-std=c++14"},
+		Tagged: map[string]*Node{
+			"mac": &Node{Frameworks: []string{"AppKit", "Metal"}},
+		},
+	}
+	graph := &Graph{Nodes: []*Node{node}, Sources: []*Node{node}}
+	env := &Environment{OutDir: "out", Tags: []string{"mac"}}
+
+	generator := &NinjaGenerator{}
+	generator.Generate(env, graph)
+
+	builds := map[string]*NinjaBuild{}
+	for _, build := range generator.Nodes {
+		if len(build.Outputs) > 0 {
+			builds[build.Outputs[0]] = build
+		}
+	}
+
+	for _, test := range []struct {
+		output   string
+		rule     string
+		variable string
+		flags    string
+	}{
+		{"out/obj/app/src/main.cpp.o", "compile", "cflags_cc", ""},
+		{"out/obj/app/src/window.m.o", "compile_objc", "cflags_objc", "-fobjc-arc"},
+		{"out/obj/app/src/renderer.mm.o", "compile_objcxx", "cflags_objcc", "-fobjc-arc -std=c++14"},
+	} {
+		build := builds[test.output]
+		if build == nil {
+			t.Errorf("No build statement for %s", test.output)
+			continue
+		}
+		if build.Rule != test.rule {
+			t.Errorf("Unexpected rule of %s: %s", test.output, build.Rule)
+		}
+		if flags, ok := build.Variables[test.variable]; !ok || flags != test.flags {
+			t.Errorf("Unexpected %s of %s: %v", test.variable, test.output, build.Variables)
+		}
+	}
+
+	link := builds["out/bin/app"]
+	if link == nil || !strings.Contains(link.Variables["ldflags"], "-framework AppKit -framework Metal") {
+		t.Errorf("Unexpected link statement: %+v", link)
+	}
+}
+
+func TestGenerateConfigsFromManifest(t *testing.T) {
+	app := &Node{
+		Name:    "app",
+		Type:    OutputTypeExecutable,
+		Sources: []string{"main.cpp"},
+		Tagged: map[string]*Node{
+			"debug":   &Node{Defines: []string{"DEBUG"}},
+			"release": &Node{Defines: []string{"NDEBUG"}},
+		},
+	}
+	graph := &Graph{
+		Nodes:   []*Node{app},
+		Sources: []*Node{app},
+		Configurations: []Configuration{
+			{Name: "Debug", Tags: []string{"debug"}},
+			{Name: "Release", Tags: []string{"release"}, Features: []string{"lto"}},
+		},
+	}
+	options := &NinjaOptions{NinjaFile: "build.ninja", Configs: []string{"Debug", "Release"}}
+
+	envs := []*Environment{}
+	for _, name := range options.Configs {
+		configOptions := *options
+		configOptions.Config = name
+		envs = append(envs, newNinjaEnvironmentFromOptions(graph, &configOptions))
+	}
+	if envs[0].OutDir != "out/Debug" || envs[1].OutDir != "out/Release" {
+		t.Errorf("Unexpected output directories: %s, %s", envs[0].OutDir, envs[1].OutDir)
+	}
+	lto, err := envs[1].Toolchain.GetFeature("lto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs[0].FeatureFlags) != 0 || !reflect.DeepEqual(envs[1].FeatureFlags, []Feature{lto}) {
+		t.Errorf("Unexpected features: %v, %v", envs[0].FeatureFlags, envs[1].FeatureFlags)
+	}
+
+	generator := &NinjaGenerator{}
+	generator.GenerateConfigs(envs, graph)
+
+	defines := map[string]string{}
+	phonies := map[string][]string{}
+	for _, build := range generator.Nodes {
+		if build.Rule == "compile" {
+			defines[build.Outputs[0]] = build.Variables["defines"]
+		} else if build.Rule == "phony" {
+			phonies[build.Outputs[0]] = build.Inputs
+		}
+	}
+	expectedDefines := map[string]string{
+		"out/Debug/obj/app/main.cpp.o":   "-DDEBUG",
+		"out/Release/obj/app/main.cpp.o": "-DNDEBUG",
+	}
+	if !reflect.DeepEqual(defines, expectedDefines) {
+		t.Errorf("Unexpected defines:\n%v", defines)
+	}
+	if !reflect.DeepEqual(phonies["Release:app"], []string{"out/Release/bin/app"}) {
+		t.Errorf("Unexpected phony targets:\n%v", phonies)
+	}
+}
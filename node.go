@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 )
 
 // OutputType specifies the output type of target being defined.
@@ -19,27 +20,74 @@ const (
 
 	// OutputTypeDynamicLibrary indicates the output type is dynamic library.
 	OutputTypeDynamicLibrary
+
+	// OutputTypeAction indicates the target runs custom commands to generate files.
+	OutputTypeAction
+
+	// OutputTypeTest indicates the output type is executable that runs tests.
+	OutputTypeTest
+
+	// OutputTypeExternal indicates the target is a library installed in the system.
+	OutputTypeExternal
+
+	// OutputTypePrebuiltLibrary indicates the target is a library built outside of the manifests.
+	OutputTypePrebuiltLibrary
 )
 
 // Node represents a node in a dependency graph.
 type Node struct {
-	Name            string
-	Type            OutputType
-	Headers         []string
-	Sources         []string
-	IncludeDirs     []string
-	LibDirs         []string
-	Defines         []string
-	CompilerFlags   []string
-	CompilerFlagsC  []string
-	CompilerFlagsCC []string
-	LinkerFlags     []string
-	MSBuildSettings MSBuildSettings
-	MSBuildProject  MSBuildProject
-	Templates       Templates
-	Dependencies    []*Node
-	Configs         []*Node
-	Tagged          map[string]*Node
+	Name               string
+	Type               OutputType
+	Headers            []string
+	Sources            []string
+	IncludeDirs        []string
+	LibDirs            []string
+	Libraries          []string
+	Defines            []string
+	CompilerFlags      []string
+	CompilerFlagsC     []string
+	CompilerFlagsCC    []string
+	CompilerFlagsObjC  []string
+	CompilerFlagsObjCC []string
+	AssemblerFlags     []string
+	NasmFlags          []string
+	ResourceFlags      []string
+	SourceSettings     []SourceSettings
+	Actions            []Action
+	Test               TestSettings
+	LinkerFlags        []string
+	Frameworks         []string
+	Pool               string
+	Host               bool
+	Emscripten         EmscriptenSettings
+	Install            InstallSettings
+	External           ExternalSettings
+	MSBuildSettings    MSBuildSettings
+	MSBuildProject     MSBuildProject
+	Templates          Templates
+	Dependencies       []*Node
+	Configs            []*Node
+	Tagged             map[string]*Node
+}
+
+// getTransitiveDeps gets the nodes of the type that the node depends on directly or indirectly.
+func getTransitiveDeps(node *Node, outputType OutputType) (result []*Node) {
+	visited := map[*Node]bool{}
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		for _, dep := range node.Dependencies {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if dep.Type == outputType {
+				result = append(result, dep)
+			}
+			visit(dep)
+		}
+	}
+	visit(node)
+	return result
 }
 
 // GetHeaders gets the paths of the header files.
@@ -98,6 +146,20 @@ func (node *Node) GetLibDirs(env *Environment) (result []string) {
 	return result
 }
 
+// GetLibraries gets the paths of the library files of the prebuilt library.
+func (node *Node) GetLibraries(env *Environment) (result []string) {
+	result = append(result, node.Libraries...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.Libraries...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetLibraries(env)...)
+	}
+	return result
+}
+
 // GetDefines gets a set of the preprocessor macros defined.
 func (node *Node) GetDefines(env *Environment) (result []string) {
 	result = append(result, node.Defines...)
@@ -154,6 +216,110 @@ func (node *Node) GetCompilerFlagsCC(env *Environment) (result []string) {
 	return result
 }
 
+// GetCompilerFlagsObjC gets a set of the Objective-C compiler flags.
+func (node *Node) GetCompilerFlagsObjC(env *Environment) (result []string) {
+	result = append(result, node.CompilerFlagsObjC...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.CompilerFlagsObjC...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetCompilerFlagsObjC(env)...)
+	}
+	return result
+}
+
+// GetCompilerFlagsObjCC gets a set of the Objective-C++ compiler flags.
+func (node *Node) GetCompilerFlagsObjCC(env *Environment) (result []string) {
+	result = append(result, node.CompilerFlagsObjCC...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.CompilerFlagsObjCC...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetCompilerFlagsObjCC(env)...)
+	}
+	return result
+}
+
+// GetAssemblerFlags gets a set of the assembler flags.
+func (node *Node) GetAssemblerFlags(env *Environment) (result []string) {
+	result = append(result, node.AssemblerFlags...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.AssemblerFlags...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetAssemblerFlags(env)...)
+	}
+	return result
+}
+
+// GetNasmFlags gets a set of the NASM assembler flags.
+func (node *Node) GetNasmFlags(env *Environment) (result []string) {
+	result = append(result, node.NasmFlags...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.NasmFlags...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetNasmFlags(env)...)
+	}
+	return result
+}
+
+// GetResourceFlags gets a set of the resource compiler flags.
+func (node *Node) GetResourceFlags(env *Environment) (result []string) {
+	result = append(result, node.ResourceFlags...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.ResourceFlags...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetResourceFlags(env)...)
+	}
+	return result
+}
+
+// GetSourceSettings gets the configuration settings that apply to the source file.
+func (node *Node) GetSourceSettings(env *Environment, source string) (result []SourceSettings) {
+	match := func(settings []SourceSettings) {
+		for _, s := range settings {
+			// NOTE: The globs are validated by validateGraph.
+			if matched, _ := filepath.Match(s.Glob, source); matched {
+				result = append(result, s)
+			}
+		}
+	}
+	match(node.SourceSettings)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			match(tagged.SourceSettings)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetSourceSettings(env, source)...)
+	}
+	return result
+}
+
+// GetActions gets the custom commands to run before compiling the sources.
+func (node *Node) GetActions(env *Environment) (result []Action) {
+	// NOTE: Actions are not inherited from configs because each output must be generated only once.
+	result = append(result, node.Actions...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.Actions...)
+		}
+	}
+	return result
+}
+
 // GetLinkerFlags gets a set of the linker flags.
 func (node *Node) GetLinkerFlags(env *Environment) (result []string) {
 	result = append(result, node.LinkerFlags...)
@@ -168,6 +334,124 @@ func (node *Node) GetLinkerFlags(env *Environment) (result []string) {
 	return result
 }
 
+// GetFrameworks gets the names of the Apple frameworks to link with.
+func (node *Node) GetFrameworks(env *Environment) (result []string) {
+	result = append(result, node.Frameworks...)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			result = append(result, tagged.Frameworks...)
+		}
+	}
+	for _, c := range node.Configs {
+		result = append(result, c.GetFrameworks(env)...)
+	}
+	return result
+}
+
+// GetPool gets the name of the pool that the build commands of the target run in.
+func (node *Node) GetPool(env *Environment) string {
+	// NOTE: The pool of the tagged settings overrides the default one.
+	for i := len(env.Tags) - 1; i >= 0; i-- {
+		if tagged := node.Tagged[env.Tags[i]]; tagged != nil && len(tagged.Pool) > 0 {
+			return tagged.Pool
+		}
+	}
+	if len(node.Pool) > 0 {
+		return node.Pool
+	}
+	for _, c := range node.Configs {
+		if pool := c.GetPool(env); len(pool) > 0 {
+			return pool
+		}
+	}
+	return ""
+}
+
+// GetEmscriptenSettings gets the link settings for Emscripten.
+func (node *Node) GetEmscriptenSettings(env *Environment) EmscriptenSettings {
+	result := EmscriptenSettings{Settings: map[string]string{}}
+	merge := func(other *EmscriptenSettings) {
+		result.ExportedFunctions = append(result.ExportedFunctions, other.ExportedFunctions...)
+		result.PreloadFiles = append(result.PreloadFiles, other.PreloadFiles...)
+		result.AllowMemoryGrowth = result.AllowMemoryGrowth || other.AllowMemoryGrowth
+		if result.InitialMemory == 0 {
+			result.InitialMemory = other.InitialMemory
+		}
+		mergeMSBuildSettingsMap(&result.Settings, &other.Settings)
+	}
+
+	merge(&node.Emscripten)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			merge(&tagged.Emscripten)
+		}
+	}
+	for _, c := range node.Configs {
+		other := c.GetEmscriptenSettings(env)
+		merge(&other)
+	}
+	result.ExportedFunctions = removeDuplicatesFromSlice(result.ExportedFunctions)
+	result.PreloadFiles = removeDuplicatesFromSlice(result.PreloadFiles)
+	return result
+}
+
+// GetInstallSettings gets the files to install. The directories of the target
+// take precedence over the tagged ones and the ones of the configs.
+func (node *Node) GetInstallSettings(env *Environment) InstallSettings {
+	result := InstallSettings{}
+	merge := func(other *InstallSettings) {
+		result.Output = result.Output || other.Output
+		result.Headers = append(result.Headers, other.Headers...)
+		if len(result.IncludeDir) == 0 {
+			result.IncludeDir = other.IncludeDir
+		}
+		if len(result.BinDir) == 0 {
+			result.BinDir = other.BinDir
+		}
+		if len(result.LibDir) == 0 {
+			result.LibDir = other.LibDir
+		}
+		if len(result.Version) == 0 {
+			result.Version = other.Version
+		}
+	}
+
+	merge(&node.Install)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			merge(&tagged.Install)
+		}
+	}
+	for _, c := range node.Configs {
+		other := c.GetInstallSettings(env)
+		merge(&other)
+	}
+	return result
+}
+
+// GetExternalSettings gets the packages of the external library. The packages
+// and the paths are appended and the libraries are static if any settings say so.
+func (node *Node) GetExternalSettings(env *Environment) ExternalSettings {
+	result := ExternalSettings{}
+	merge := func(other *ExternalSettings) {
+		result.Packages = append(result.Packages, other.Packages...)
+		result.Paths = append(result.Paths, other.Paths...)
+		result.Static = result.Static || other.Static
+	}
+
+	merge(&node.External)
+	for _, tag := range env.Tags {
+		if tagged := node.Tagged[tag]; tagged != nil {
+			merge(&tagged.External)
+		}
+	}
+	for _, c := range node.Configs {
+		other := c.GetExternalSettings(env)
+		merge(&other)
+	}
+	return result
+}
+
 func copyMSBuildProjectConfiguration(dst, src *MSBuildProjectConfiguration) {
 	dst.Configuration = src.Configuration
 	dst.Platform = src.Platform
@@ -176,6 +460,7 @@ func copyMSBuildProjectConfiguration(dst, src *MSBuildProjectConfiguration) {
 	dst.DynamicLibraryExtension = src.DynamicLibraryExtension
 	dst.Tags = make([]string, len(src.Tags))
 	copy(dst.Tags, src.Tags)
+	dst.Features = append([]string{}, src.Features...)
 }
 
 // GetMSBuildProject gets configuration details of MSBuild.
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// isPackageNode returns true if the pkg-config and CMake package files are generated
+// for the node. The packages are generated for the installed static libraries.
+func isPackageNode(env *Environment, node *Node) bool {
+	return node.Type == OutputTypeStaticLibrary && node.GetInstallSettings(env).Output
+}
+
+// packageInfo represents the usage requirements of a library for the package files.
+type packageInfo struct {
+	Name        string
+	Version     string
+	IncludeDir  string
+	LibDir      string
+	LibFile     string
+	Defines     []string
+	LinkerFlags []string
+	Frameworks  []string
+	Requires    []string
+
+	// NOTE: The packages of the external libraries are required by pkg-config
+	// and the resolved flags are used by CMake.
+	Packages              []string
+	ExternalCompilerFlags []string
+	ExternalLinkerFlags   []string
+}
+
+// getPackageInfo gets the package of the node built in the environment.
+func getPackageInfo(env *Environment, node *Node) *packageInfo {
+	settings := node.GetInstallSettings(env)
+	info := &packageInfo{
+		Name:       node.Name,
+		Version:    settings.Version,
+		IncludeDir: settings.IncludeDir,
+		LibDir:     settings.LibDir,
+		LibFile:    filepath.Base(getStaticLibraryFile(env, node)),
+		Defines:    node.GetDefines(env),
+	}
+	if len(info.Version) == 0 {
+		info.Version = "0"
+	}
+	if len(info.IncludeDir) == 0 {
+		info.IncludeDir = "include"
+	}
+	if len(info.LibDir) == 0 {
+		info.LibDir = "lib"
+	}
+
+	// NOTE: The static libraries contain the objects of the dependencies, so the consumers
+	// need only the link flags of them. The installed ones are required as packages.
+	visited := map[*Node]bool{}
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+		info.LinkerFlags = append(info.LinkerFlags, node.GetLinkerFlags(env)...)
+		info.Frameworks = append(info.Frameworks, node.GetFrameworks(env)...)
+		for _, dep := range node.Dependencies {
+			if dep.Type == OutputTypeExternal {
+				settings := dep.GetExternalSettings(env)
+				info.Packages = append(info.Packages, getExternalPackages(&settings, dep)...)
+				flags, err := resolveExternal(env, dep)
+				if err != nil {
+					log.Fatalln("error:", err)
+				}
+				info.ExternalCompilerFlags = append(info.ExternalCompilerFlags, flags.CompilerFlags...)
+				info.ExternalLinkerFlags = append(info.ExternalLinkerFlags, flags.LinkerFlags...)
+				continue
+			}
+			if dep.Type != OutputTypeStaticLibrary {
+				continue
+			}
+			if isPackageNode(env, dep) {
+				info.Requires = append(info.Requires, dep.Name)
+			}
+			visit(dep)
+		}
+	}
+	visit(node)
+
+	info.LinkerFlags = removeDuplicateLinkerFlags(info.LinkerFlags)
+	info.Frameworks = removeDuplicatesFromSlice(info.Frameworks)
+	info.Requires = removeDuplicatesFromSlice(info.Requires)
+	info.Packages = removeDuplicatesFromSlice(info.Packages)
+	info.ExternalCompilerFlags = removeDuplicateCompilerFlags(info.ExternalCompilerFlags)
+	info.ExternalLinkerFlags = removeDuplicateLinkerFlags(info.ExternalLinkerFlags)
+	return info
+}
+
+// getPrefixFromDir gets the relative path from the directory in the prefix to the prefix.
+func getPrefixFromDir(dir string) string {
+	depth := len(strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/"))
+	return strings.TrimSuffix(strings.Repeat("../", depth), "/")
+}
+
+func getPkgConfigFile(info *packageInfo) string {
+	return filepath.Join(info.LibDir, "pkgconfig", info.Name+".pc")
+}
+
+func getCMakeConfigFile(info *packageInfo) string {
+	return filepath.Join(info.LibDir, "cmake", info.Name, info.Name+"Config.cmake")
+}
+
+// generatePkgConfig generates the pkg-config file. The prefix is relative
+// to ${pcfiledir} so that the install tree can be moved.
+func generatePkgConfig(info *packageInfo) string {
+	cflags := []string{"-I${includedir}"}
+	for _, define := range info.Defines {
+		cflags = append(cflags, quoteShellArg("-D"+define))
+	}
+	libs := []string{"-L${libdir}", "-l" + info.Name}
+	libs = append(libs, info.LinkerFlags...)
+	for _, framework := range info.Frameworks {
+		libs = append(libs, "-framework "+framework)
+	}
+
+	str := fmt.Sprintf("prefix=${pcfiledir}/%s\n", getPrefixFromDir(filepath.Dir(getPkgConfigFile(info))))
+	str += fmt.Sprintf("includedir=${prefix}/%s\n", filepath.ToSlash(info.IncludeDir))
+	str += fmt.Sprintf("libdir=${prefix}/%s\n", filepath.ToSlash(info.LibDir))
+	str += "\n"
+	str += fmt.Sprintf("Name: %s\n", info.Name)
+	str += fmt.Sprintf("Description: %s library\n", info.Name)
+	str += fmt.Sprintf("Version: %s\n", info.Version)
+	if requires := append(append([]string{}, info.Requires...), info.Packages...); len(requires) > 0 {
+		str += fmt.Sprintf("Requires: %s\n", strings.Join(requires, ", "))
+	}
+	str += fmt.Sprintf("Cflags: %s\n", strings.Join(cflags, " "))
+	str += fmt.Sprintf("Libs: %s\n", strings.Join(libs, " "))
+	return str
+}
+
+var cmakeStringReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, `;`, `\;`)
+
+func joinCMakeList(items []string) string {
+	escaped := make([]string, 0, len(items))
+	for _, item := range items {
+		escaped = append(escaped, cmakeStringReplacer.Replace(item))
+	}
+	return strings.Join(escaped, ";")
+}
+
+// generateCMakeConfig generates the CMake package file that defines the imported
+// target "<name>::<name>". The paths are relative to the package file.
+func generateCMakeConfig(info *packageInfo) string {
+	target := info.Name + "::" + info.Name
+	links := []string{}
+	for _, require := range info.Requires {
+		links = append(links, require+"::"+require)
+	}
+	links = append(links, info.LinkerFlags...)
+	links = append(links, info.ExternalLinkerFlags...)
+	for _, framework := range info.Frameworks {
+		links = append(links, "-framework "+framework)
+	}
+
+	str := "# Generated by baselard. Do not edit.\n"
+	str += fmt.Sprintf("get_filename_component(_IMPORT_PREFIX \"${CMAKE_CURRENT_LIST_DIR}/%s\" ABSOLUTE)\n",
+		getPrefixFromDir(filepath.Dir(getCMakeConfigFile(info))))
+	if len(info.Requires) > 0 {
+		str += "\ninclude(CMakeFindDependencyMacro)\n"
+		for _, require := range info.Requires {
+			str += fmt.Sprintf("find_dependency(%s PATHS \"${_IMPORT_PREFIX}\")\n", require)
+		}
+	}
+	str += "\n"
+	str += fmt.Sprintf("if(NOT TARGET %s)\n", target)
+	str += fmt.Sprintf("  add_library(%s STATIC IMPORTED)\n", target)
+	str += fmt.Sprintf("  set_target_properties(%s PROPERTIES\n", target)
+	str += fmt.Sprintf("    IMPORTED_LOCATION \"${_IMPORT_PREFIX}/%s/%s\"\n", filepath.ToSlash(info.LibDir), info.LibFile)
+	str += fmt.Sprintf("    INTERFACE_INCLUDE_DIRECTORIES \"${_IMPORT_PREFIX}/%s\"\n", filepath.ToSlash(info.IncludeDir))
+	if len(info.Defines) > 0 {
+		str += fmt.Sprintf("    INTERFACE_COMPILE_DEFINITIONS \"%s\"\n", joinCMakeList(info.Defines))
+	}
+	if len(info.ExternalCompilerFlags) > 0 {
+		str += fmt.Sprintf("    INTERFACE_COMPILE_OPTIONS \"%s\"\n", joinCMakeList(info.ExternalCompilerFlags))
+	}
+	if len(links) > 0 {
+		str += fmt.Sprintf("    INTERFACE_LINK_LIBRARIES \"%s\"\n", joinCMakeList(links))
+	}
+	str += "  )\n"
+	str += "endif()\n"
+	str += "\nunset(_IMPORT_PREFIX)\n"
+	return str
+}
+
+// generatePackageFiles generates the package files of the node built in nodeEnv
+// into "<out>/pkg" and returns them to install alongside the library.
+func (gen *NinjaGenerator) generatePackageFiles(env, nodeEnv *Environment, node *Node) (result []installFile) {
+	info := getPackageInfo(nodeEnv, node)
+	prefix := getInstallPrefix(env)
+	files := map[string]string{
+		getPkgConfigFile(info):   generatePkgConfig(info),
+		getCMakeConfigFile(info): generateCMakeConfig(info),
+	}
+	for _, file := range []string{getPkgConfigFile(info), getCMakeConfigFile(info)} {
+		source := filepath.Join(nodeEnv.OutDir, "pkg", file)
+		gen.AddFile(source, files[file])
+		result = append(result, installFile{
+			Source:      source,
+			Destination: filepath.Join(prefix, file),
+		})
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePackageFiles(t *testing.T) {
+	core := &Node{
+		Name:        "core",
+		Type:        OutputTypeStaticLibrary,
+		LinkerFlags: []string{"-pthread"},
+		Install:     InstallSettings{Output: true},
+	}
+	node := &Node{
+		Name:         "engine",
+		Type:         OutputTypeStaticLibrary,
+		Defines:      []string{"ENGINE_NAME=\"engine one\""},
+		Frameworks:   []string{"Cocoa"},
+		Dependencies: []*Node{core},
+		Install:      InstallSettings{Output: true, LibDir: "lib/x64", Version: "1.2"},
+	}
+	env := &Environment{OutDir: "out"}
+
+	info := getPackageInfo(env, node)
+	pc := generatePkgConfig(info)
+	for _, line := range []string{
+		"prefix=${pcfiledir}/../../..\n",
+		"libdir=${prefix}/lib/x64\n",
+		"Version: 1.2\n",
+		"Requires: core\n",
+		"Cflags: -I${includedir} '-DENGINE_NAME=\"engine one\"'\n",
+		"Libs: -L${libdir} -lengine -pthread -framework Cocoa\n",
+	} {
+		if !strings.Contains(pc, line) {
+			t.Errorf("Expected %q in\n%s", line, pc)
+		}
+	}
+
+	config := generateCMakeConfig(info)
+	for _, line := range []string{
+		"get_filename_component(_IMPORT_PREFIX \"${CMAKE_CURRENT_LIST_DIR}/../../../..\" ABSOLUTE)\n",
+		"find_dependency(core PATHS \"${_IMPORT_PREFIX}\")\n",
+		"IMPORTED_LOCATION \"${_IMPORT_PREFIX}/lib/x64/libengine.a\"\n",
+		"INTERFACE_COMPILE_DEFINITIONS \"ENGINE_NAME=\\\"engine one\\\"\"\n",
+		"INTERFACE_LINK_LIBRARIES \"core::core;-pthread;-framework Cocoa\"\n",
+	} {
+		if !strings.Contains(config, line) {
+			t.Errorf("Expected %q in\n%s", line, config)
+		}
+	}
+
+	gen := &NinjaGenerator{}
+	files := gen.generatePackageFiles(env, env, node)
+	if len(files) != 2 || files[0].Source != "out/pkg/lib/x64/pkgconfig/engine.pc" || files[1].Destination != "out/install/lib/x64/cmake/engine/engineConfig.cmake" {
+		t.Errorf("Unexpected files: %v", files)
+	}
+	if len(gen.Files) != 2 || gen.Files[0].Content != pc {
+		t.Errorf("Unexpected generated files: %v", gen.Files)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Platform specifies the machine that the outputs run on.
+type Platform struct {
+	Name string `toml:"name"`
+
+	// Triple is passed to clang by "-target". The platform with a triple needs a toolchain
+	// that links for it, i.e. the linker is a clang driver such as "clang++" that also gets
+	// the triple, or a cross toolchain such as "aarch64-linux-gnu-g++".
+	Triple  string `toml:"triple"`
+	Sysroot string `toml:"sysroot"`
+
+	// Toolchain is the toolchain that builds for the platform unless --toolchain is specified.
+	Toolchain string `toml:"toolchain"`
+
+	// Tags are added to the tags of the environment that builds for the platform.
+	Tags []string `toml:"tags"`
+
+	CompilerFlags []string `toml:"cflags"`
+	LinkerFlags   []string `toml:"ldflags"`
+}
+
+const (
+	// hostTag is the tag of the environment that builds the host targets.
+	hostTag = "host"
+
+	// hostRuleSuffix is appended to the names of the rules that use the host toolchain.
+	hostRuleSuffix = "_host"
+)
+
+// isClangDriver returns true if the command is the clang driver, which accepts "-target".
+func isClangDriver(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	name := strings.TrimSuffix(filepath.Base(fields[0]), ".exe")
+	return strings.HasPrefix(name, "clang") && name != "clang-cl"
+}
+
+// getFlags gets the compile and link flags that target the platform for the toolchain.
+func (platform *Platform) getFlags(toolchain *Toolchain) (cflags, ldflags []string) {
+	switch toolchain.Flavor {
+	case toolchainFlavorClang, "":
+		if len(platform.Triple) > 0 {
+			cflags = append(cflags, "-target "+platform.Triple)
+			// NOTE: The linkers such as "ld" do not accept the triple.
+			if isClangDriver(toolchain.Linker) {
+				ldflags = append(ldflags, "-target "+platform.Triple)
+			}
+		}
+		if len(platform.Sysroot) > 0 {
+			cflags = append(cflags, quoteShellArg("--sysroot="+platform.Sysroot))
+			ldflags = append(ldflags, quoteShellArg("--sysroot="+platform.Sysroot))
+		}
+	case toolchainFlavorGCC:
+		// NOTE: GCC has no option for the triple, so the toolchain names the cross compilers.
+		if len(platform.Sysroot) > 0 {
+			cflags = append(cflags, quoteShellArg("--sysroot="+platform.Sysroot))
+			ldflags = append(ldflags, quoteShellArg("--sysroot="+platform.Sysroot))
+		}
+	}
+	cflags = append(cflags, platform.CompilerFlags...)
+	ldflags = append(ldflags, platform.LinkerFlags...)
+	return cflags, ldflags
+}
+
+// getPlatformFlags gets the compile and link flags of the platform of the environment.
+func getPlatformFlags(env *Environment) (cflags, ldflags []string) {
+	if env.Platform == nil {
+		return nil, nil
+	}
+	return env.Platform.getFlags(getEnvironmentToolchain(env))
+}
+
+func hasPlatform(platforms []Platform, name string) bool {
+	for _, p := range platforms {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getPlatform gets the platform defined in the manifests.
+func getPlatform(graph *Graph, name string) (*Platform, error) {
+	for i := range graph.Platforms {
+		if graph.Platforms[i].Name == name {
+			return &graph.Platforms[i], nil
+		}
+	}
+	return nil, errors.Errorf("Platform \"%s\" is not defined", name)
+}
+
+// getNodeEnvironment gets the environment that builds the node.
+// The host targets are built for the host platform when cross compiling.
+func getNodeEnvironment(env *Environment, node *Node) *Environment {
+	if node.Host && env.Host != nil {
+		return env.Host
+	}
+	return env
+}
+
+// hasHostNodes returns true if some targets are built for the host platform.
+func hasHostNodes(nodes []*Node) bool {
+	for _, node := range nodes {
+		if node.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// getNodeEnvironments maps the nodes to the environments that build them. The dependencies
+// that only the host targets use are built only for the host platform when cross compiling.
+func getNodeEnvironments(env *Environment, nodes []*Node) map[*Node]*Environment {
+	result := map[*Node]*Environment{}
+	if env.Host == nil {
+		for _, node := range nodes {
+			result[node] = env
+		}
+		return result
+	}
+	targetNodes, hostNodes := splitHostNodes(nodes)
+	for _, node := range hostNodes {
+		result[node] = env.Host
+	}
+	for _, node := range targetNodes {
+		result[node] = env
+	}
+	return result
+}
+
+// splitHostNodes splits the nodes into the ones built for the target platform and
+// the ones built for the host platform. The dependencies of the host targets are
+// built for the host platform and also for the target platform if the other targets use them.
+func splitHostNodes(nodes []*Node) (targetNodes, hostNodes []*Node) {
+	hosts := map[*Node]bool{}
+	var visitHost func(node *Node)
+	visitHost = func(node *Node) {
+		if hosts[node] {
+			return
+		}
+		hosts[node] = true
+		for _, dep := range node.Dependencies {
+			visitHost(dep)
+		}
+	}
+	for _, node := range nodes {
+		if node.Host {
+			visitHost(node)
+		}
+	}
+
+	targets := map[*Node]bool{}
+	var visitTarget func(node *Node)
+	visitTarget = func(node *Node) {
+		if node.Host || targets[node] {
+			return
+		}
+		targets[node] = true
+		for _, dep := range node.Dependencies {
+			visitTarget(dep)
+		}
+	}
+	for _, node := range nodes {
+		if !hosts[node] {
+			visitTarget(node)
+		}
+	}
+
+	for _, node := range nodes {
+		if targets[node] {
+			targetNodes = append(targetNodes, node)
+		}
+		if hosts[node] {
+			hostNodes = append(hostNodes, node)
+		}
+	}
+	return targetNodes, hostNodes
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlatformGetFlags(t *testing.T) {
+	platform := &Platform{
+		Name:    "linux-aarch64",
+		Triple:  "aarch64-linux-gnu",
+		Sysroot: "/usr/aarch64-linux-gnu",
+	}
+
+	cflags, ldflags := platform.getFlags(getBuiltinToolchain("clang"))
+	if !reflect.DeepEqual(cflags, []string{"-target aarch64-linux-gnu", "--sysroot=/usr/aarch64-linux-gnu"}) {
+		t.Errorf("Unexpected cflags: %v", cflags)
+	}
+	if !reflect.DeepEqual(ldflags, []string{"--sysroot=/usr/aarch64-linux-gnu"}) {
+		t.Errorf("Unexpected ldflags: %v", ldflags)
+	}
+
+	_, ldflags = platform.getFlags(&Toolchain{Flavor: toolchainFlavorClang, Linker: "/usr/bin/clang++"})
+	if !reflect.DeepEqual(ldflags, []string{"-target aarch64-linux-gnu", "--sysroot=/usr/aarch64-linux-gnu"}) {
+		t.Errorf("Unexpected ldflags: %v", ldflags)
+	}
+
+	cflags, _ = platform.getFlags(&Toolchain{Flavor: toolchainFlavorGCC})
+	if !reflect.DeepEqual(cflags, []string{"--sysroot=/usr/aarch64-linux-gnu"}) {
+		t.Errorf("Unexpected cflags: %v", cflags)
+	}
+
+	cflags, ldflags = platform.getFlags(getBuiltinToolchain("clang-cl"))
+	if len(cflags) != 0 || len(ldflags) != 0 {
+		t.Errorf("Unexpected flags: %v %v", cflags, ldflags)
+	}
+}
+
+func TestIsClangDriver(t *testing.T) {
+	for command, expected := range map[string]bool{
+		"clang":                 true,
+		"/usr/bin/clang++-14":   true,
+		"clang++.exe":           true,
+		"clang-cl":              false,
+		"ld":                    false,
+		"aarch64-linux-gnu-g++": false,
+		"":                      false,
+	} {
+		if actual := isClangDriver(command); actual != expected {
+			t.Errorf("isClangDriver(%q) = %v", command, actual)
+		}
+	}
+}
+
+func TestSplitHostNodes(t *testing.T) {
+	shared := &Node{Name: "shared", Type: OutputTypeStaticLibrary}
+	hostOnly := &Node{Name: "host_only", Type: OutputTypeStaticLibrary}
+	gen := &Node{Name: "gen", Type: OutputTypeExecutable, Host: true, Dependencies: []*Node{shared, hostOnly}}
+	action := &Node{Name: "table", Type: OutputTypeAction, Dependencies: []*Node{gen}}
+	app := &Node{Name: "app", Type: OutputTypeExecutable, Dependencies: []*Node{shared, action}}
+
+	targetNodes, hostNodes := splitHostNodes([]*Node{shared, hostOnly, gen, action, app})
+	if !reflect.DeepEqual(targetNodes, []*Node{shared, action, app}) {
+		t.Errorf("Unexpected target nodes: %v", targetNodes)
+	}
+	if !reflect.DeepEqual(hostNodes, []*Node{shared, hostOnly, gen}) {
+		t.Errorf("Unexpected host nodes: %v", hostNodes)
+	}
+
+	env := &Environment{OutDir: "out", Host: &Environment{OutDir: "out/host"}}
+	if actual := getActionTools(env, action); !reflect.DeepEqual(actual, []string{"out/host/bin/gen"}) {
+		t.Errorf("Unexpected tools: %v", actual)
+	}
+}
+
+func TestHostOnlyDependencyOutputs(t *testing.T) {
+	support := &Node{Name: "support", Type: OutputTypeStaticLibrary, Sources: []string{"support.cpp"}, Install: InstallSettings{Output: true}}
+	gen := &Node{Name: "gen", Type: OutputTypeExecutable, Host: true, Sources: []string{"gen.cpp"}, Dependencies: []*Node{support}}
+	app := &Node{Name: "app", Type: OutputTypeExecutable, Sources: []string{"main.cpp"}}
+	graph := &Graph{Nodes: []*Node{support, gen, app}, Sources: []*Node{gen, app}}
+	env := &Environment{OutDir: "out", Host: &Environment{OutDir: "out/host", RuleSuffix: hostRuleSuffix}}
+
+	generator := &NinjaGenerator{}
+	generator.Generate(env, graph)
+
+	phonies := map[string][]string{}
+	for _, build := range generator.Nodes {
+		if build.Rule == "phony" {
+			phonies[build.Outputs[0]] = build.Inputs
+		}
+	}
+	if !reflect.DeepEqual(phonies["support"], []string{"out/host/bin/libsupport.a"}) {
+		t.Errorf("Unexpected alias: %v", phonies["support"])
+	}
+	if !reflect.DeepEqual(phonies["all"], []string{"out/host/bin/libsupport.a", "out/host/bin/gen", "out/bin/app"}) {
+		t.Errorf("Unexpected all: %v", phonies["all"])
+	}
+	for _, inputs := range phonies {
+		for _, input := range inputs {
+			if !generator.HasOutput(input) {
+				t.Errorf("No build statement produces %s", input)
+			}
+		}
+	}
+
+	if actual := getNinjaTargets(env, graph, []string{"support"}); !reflect.DeepEqual(actual, []string{"out/host/bin/libsupport.a"}) {
+		t.Errorf("Unexpected targets: %v", actual)
+	}
+	if !generator.HasOutput("out/install/lib/libsupport.a") {
+		t.Errorf("The host library must be installed")
+	}
+	for _, build := range generator.Nodes {
+		if build.Rule == "install" && build.Outputs[0] == "out/install/lib/libsupport.a" && build.Inputs[0] != "out/host/bin/libsupport.a" {
+			t.Errorf("Unexpected install source: %v", build.Inputs)
+		}
+	}
+}
+
+func TestGenerateHostRules(t *testing.T) {
+	app := &Node{Name: "app", Type: OutputTypeExecutable, Sources: []string{"main.cpp"}}
+	gen := &Node{Name: "gen", Type: OutputTypeExecutable, Host: true, Sources: []string{"gen.cpp"}}
+	env := &Environment{OutDir: "out", Host: &Environment{OutDir: "out/host", RuleSuffix: hostRuleSuffix}}
+
+	generator := &NinjaGenerator{}
+	generator.Generate(env, &Graph{Nodes: []*Node{app}, Sources: []*Node{app}})
+	if rule := generator.getRule("compile" + hostRuleSuffix); rule != nil {
+		t.Errorf("The host rules must not be generated without host targets")
+	}
+
+	generator = &NinjaGenerator{}
+	generator.Generate(env, &Graph{Nodes: []*Node{app, gen}, Sources: []*Node{app, gen}})
+	if rule := generator.getRule("compile" + hostRuleSuffix); rule == nil {
+		t.Errorf("The host rules must be generated for the host targets")
+	}
+}
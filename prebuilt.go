@@ -0,0 +1,29 @@
+package main
+
+// NOTE: The prebuilt libraries are not compiled, so the targets depending on them
+// use their include directories and defines and the executables link their files.
+
+// getPrebuiltLibraries gets the library files of the prebuilt libraries that the node depends on.
+// Static libraries cannot carry them, so the executable links them instead.
+func getPrebuiltLibraries(env *Environment, node *Node) (result []string) {
+	for _, dep := range getTransitiveDeps(node, OutputTypePrebuiltLibrary) {
+		result = append(result, dep.GetLibraries(env)...)
+	}
+	return removeDuplicatesFromSlice(result)
+}
+
+// getPrebuiltIncludeDirs gets the include directories of the prebuilt libraries that the node depends on.
+func getPrebuiltIncludeDirs(env *Environment, node *Node) (result []string) {
+	for _, dep := range getTransitiveDeps(node, OutputTypePrebuiltLibrary) {
+		result = append(result, dep.GetIncludeDirs(env)...)
+	}
+	return removeDuplicatesFromSlice(result)
+}
+
+// getPrebuiltDefines gets the preprocessor macros of the prebuilt libraries that the node depends on.
+func getPrebuiltDefines(env *Environment, node *Node) (result []string) {
+	for _, dep := range getTransitiveDeps(node, OutputTypePrebuiltLibrary) {
+		result = append(result, dep.GetDefines(env)...)
+	}
+	return removeDuplicatesFromSlice(result)
+}
@@ -1,6 +1,9 @@
 package main
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"strings"
+)
 
 // SourceFileType specifies the file type to associate with the file extension.
 type SourceFileType int
@@ -23,6 +26,18 @@ const (
 
 	// SourceFileTypeObjCpp indicates the file is a Objective-C++ source file.
 	SourceFileTypeObjCpp
+
+	// SourceFileTypeAsm indicates the file is a assembly source file.
+	SourceFileTypeAsm
+
+	// SourceFileTypeAsmCpp indicates the file is a assembly source file that needs the C preprocessor.
+	SourceFileTypeAsmCpp
+
+	// SourceFileTypeNasm indicates the file is a assembly source file for NASM/MASM.
+	SourceFileTypeNasm
+
+	// SourceFileTypeResource indicates the file is a Windows resource script.
+	SourceFileTypeResource
 )
 
 func getSourceFileType(filename string) SourceFileType {
@@ -38,6 +53,50 @@ func getSourceFileType(filename string) SourceFileType {
 		return SourceFileTypeObjC
 	} else if ext == ".mm" {
 		return SourceFileTypeObjCpp
+	} else if ext == ".s" {
+		return SourceFileTypeAsm
+	} else if ext == ".S" {
+		return SourceFileTypeAsmCpp
+	} else if ext == ".asm" {
+		return SourceFileTypeNasm
+	} else if ext == ".rc" {
+		return SourceFileTypeResource
 	}
 	return SourceFileTypeUnknown
 }
+
+// sourceFileTypeNames maps the language names used in [file_types] to file types.
+var sourceFileTypeNames = map[string]SourceFileType{
+	"c":        SourceFileTypeCSource,
+	"c++":      SourceFileTypeCppSource,
+	"cpp":      SourceFileTypeCppSource,
+	"header":   SourceFileTypeCppHeader,
+	"objc":     SourceFileTypeObjC,
+	"objc++":   SourceFileTypeObjCpp,
+	"objcpp":   SourceFileTypeObjCpp,
+	"asm":      SourceFileTypeAsm,
+	"asm_cpp":  SourceFileTypeAsmCpp,
+	"nasm":     SourceFileTypeNasm,
+	"resource": SourceFileTypeResource,
+	"rc":       SourceFileTypeResource,
+}
+
+func parseSourceFileType(name string) (SourceFileType, bool) {
+	fileType, ok := sourceFileTypeNames[strings.ToLower(name)]
+	return fileType, ok
+}
+
+// SourceFileTypes maps file extensions to user-defined file types.
+type SourceFileTypes map[string]SourceFileType
+
+// Get gets the file type of the file, falling back to the built-in file types.
+func (types SourceFileTypes) Get(filename string) SourceFileType {
+	if fileType, ok := types[filepath.Ext(filename)]; ok {
+		return fileType
+	}
+	return getSourceFileType(filename)
+}
+
+func isSourceFileType(fileType SourceFileType) bool {
+	return fileType != SourceFileTypeUnknown && fileType != SourceFileTypeCppHeader
+}
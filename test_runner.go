@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TestOptions specifies how to run tests.
+type TestOptions struct {
+	Jobs      int
+	Labels    []string
+	JUnitFile string
+}
+
+// TestResult represents a result of a test executable.
+type TestResult struct {
+	Name     string
+	Passed   bool
+	TimedOut bool
+	Output   string
+	Error    string
+	Duration time.Duration
+}
+
+func hasTestLabel(node *Node, labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		for _, l := range node.Test.Labels {
+			if l == label {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getTestNodes returns the test targets that match the names and the labels.
+// It returns an error if some names match no test target.
+func getTestNodes(graph *Graph, names, labels []string) ([]*Node, error) {
+	result := []*Node{}
+	matched := map[string]bool{}
+	for _, node := range graph.Nodes {
+		if node.Type != OutputTypeTest {
+			continue
+		}
+		if len(names) > 0 {
+			found := false
+			for _, name := range names {
+				_, targetName := splitManifestTarget(name)
+				if node.Name == targetName {
+					matched[name] = true
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if hasTestLabel(node, labels) {
+			result = append(result, node)
+		}
+	}
+
+	unknownNames := []string{}
+	for _, name := range names {
+		if !matched[name] {
+			unknownNames = append(unknownNames, name)
+		}
+	}
+	if len(unknownNames) > 0 {
+		return nil, errors.Errorf("No test target matches %s", strings.Join(unknownNames, ", "))
+	}
+	return result, nil
+}
+
+func runTest(env *Environment, node *Node) TestResult {
+	result := TestResult{Name: node.Name}
+
+	executableFile, err := filepath.Abs(getExecutableFile(getNodeEnvironment(env, node), node))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx := context.Background()
+	if node.Test.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(node.Test.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, executableFile, node.Test.Args...)
+	if strings.HasSuffix(executableFile, ".js") {
+		// NOTE: The tests built with Emscripten run on Node.js.
+		cmd = exec.CommandContext(ctx, "node", append([]string{executableFile}, node.Test.Args...)...)
+	}
+	cmd.Dir = node.Test.WorkingDir
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// NOTE: The children of the test may keep the output open after it is killed.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.TimedOut = true
+		result.Error = fmt.Sprintf("Timed out after %d seconds", node.Test.Timeout)
+	case err != nil:
+		result.Error = err.Error()
+	default:
+		result.Passed = true
+	}
+	return result
+}
+
+func runTestNodes(env *Environment, nodes []*Node, jobs int) []TestResult {
+	if jobs <= 0 {
+		jobs = 1
+	}
+
+	results := make([]TestResult, len(nodes))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				results[index] = runTest(env, nodes[index])
+			}
+		}()
+	}
+	for i := range nodes {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+// JUnitFailure represents a failure element in a JUnit XML report.
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnitTestCase represents a testcase element in a JUnit XML report.
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// JUnitTestSuite represents a testsuite element in a JUnit XML report.
+type JUnitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+func writeJUnitReport(filename string, results []TestResult) error {
+	suite := JUnitTestSuite{
+		Name:  "baselard",
+		Tests: len(results),
+	}
+
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		testCase := JUnitTestCase{
+			Name:      result.Name,
+			ClassName: result.Name,
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+			SystemOut: result.Output,
+		}
+		if !result.Passed {
+			suite.Failures++
+			testCase.Failure = &JUnitFailure{
+				Message: result.Error,
+				Text:    result.Output,
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	xmlString, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "Failed to create output directory \"%s\"", dir)
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	writer.WriteString(xml.Header)
+	writer.Write(xmlString)
+	writer.WriteString("\n")
+	return writer.Flush()
+}
+
+func runTests(env *Environment, graph *Graph, ninjaFile string, names []string, options *TestOptions) (bool, error) {
+	nodes, err := getTestNodes(graph, names, options.Labels)
+	if err != nil {
+		return false, err
+	}
+	if len(nodes) == 0 {
+		fmt.Println("No tests to run.")
+		return true, nil
+	}
+
+	executableFiles := []string{}
+	for _, node := range nodes {
+		executableFiles = append(executableFiles, getExecutableFile(getNodeEnvironment(env, node), node))
+	}
+	if err := runNinja(ninjaFile, executableFiles, 0); err != nil {
+		return false, errors.Wrap(err, "Failed to build tests")
+	}
+
+	results := runTestNodes(env, nodes, options.Jobs)
+
+	passed := 0
+	for _, result := range results {
+		if result.Passed {
+			passed++
+			fmt.Printf("[PASS] %s (%.2fs)\n", result.Name, result.Duration.Seconds())
+			continue
+		}
+		fmt.Printf("[FAIL] %s (%.2fs): %s\n", result.Name, result.Duration.Seconds(), result.Error)
+		fmt.Print(result.Output)
+	}
+	fmt.Printf("%d of %d tests passed\n", passed, len(results))
+
+	if len(options.JUnitFile) > 0 {
+		if err := writeJUnitReport(options.JUnitFile, results); err != nil {
+			return false, err
+		}
+		fmt.Println("Generate", options.JUnitFile)
+	}
+	return passed == len(results), nil
+}
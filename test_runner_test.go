@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHasTestLabel(t *testing.T) {
+	node := &Node{Test: TestSettings{Labels: []string{"unit", "fast"}}}
+	if !hasTestLabel(node, nil) {
+		t.Error("Expected any node to match no labels")
+	}
+	if !hasTestLabel(node, []string{"slow", "fast"}) {
+		t.Error("Expected the node to match one of the labels")
+	}
+	if hasTestLabel(node, []string{"slow"}) {
+		t.Error("Expected the node not to match the label")
+	}
+}
+
+func TestGetTestNodes(t *testing.T) {
+	graph := &Graph{
+		Nodes: []*Node{
+			&Node{Name: "a", Type: OutputTypeTest, Test: TestSettings{Labels: []string{"unit"}}},
+			&Node{Name: "b", Type: OutputTypeTest, Test: TestSettings{Labels: []string{"integration"}}},
+			&Node{Name: "c", Type: OutputTypeExecutable},
+		},
+	}
+	getNames := func(nodes []*Node) (result []string) {
+		for _, node := range nodes {
+			result = append(result, node.Name)
+		}
+		return result
+	}
+
+	nodes, err := getTestNodes(graph, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := getNames(nodes); !reflect.DeepEqual(actual, []string{"a", "b"}) {
+		t.Errorf("Unexpected tests: %v", actual)
+	}
+
+	nodes, err = getTestNodes(graph, []string{"build.toml:b"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := getNames(nodes); !reflect.DeepEqual(actual, []string{"b"}) {
+		t.Errorf("Unexpected tests: %v", actual)
+	}
+
+	nodes, err = getTestNodes(graph, []string{"a", "b"}, []string{"unit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := getNames(nodes); !reflect.DeepEqual(actual, []string{"a"}) {
+		t.Errorf("Unexpected tests: %v", actual)
+	}
+
+	_, err = getTestNodes(graph, []string{"a", "c", "d"}, nil)
+	if err == nil || err.Error() != "No test target matches c, d" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "reports", "junit.xml")
+	results := []TestResult{
+		{Name: "a", Passed: true, Output: "ok\n", Duration: 1500 * time.Millisecond},
+		{Name: "b", TimedOut: true, Output: "hang\n", Error: "Timed out after 1 seconds", Duration: time.Second},
+	}
+	if err := writeJUnitReport(filename, results); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite JUnitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Time != "2.500" {
+		t.Errorf("Unexpected test suite: %+v", suite)
+	}
+	if len(suite.TestCases) != 2 {
+		t.Fatalf("Unexpected test cases: %+v", suite.TestCases)
+	}
+	if passed := suite.TestCases[0]; passed.Failure != nil || passed.Time != "1.500" || passed.SystemOut != "ok\n" {
+		t.Errorf("Unexpected test case: %+v", passed)
+	}
+	failed := suite.TestCases[1]
+	if failed.Failure == nil || failed.Failure.Message != "Timed out after 1 seconds" || failed.Failure.Text != "hang\n" {
+		t.Errorf("Unexpected test case: %+v", failed)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Feature specifies the flags to enable a build variant such as sanitizers.
+type Feature struct {
+	CompilerFlags []string `toml:"cflags"`
+	LinkerFlags   []string `toml:"ldflags"`
+}
+
+// Toolchain specifies the tools that the ninja rules run.
+type Toolchain struct {
+	Name     string `toml:"name"`
+	Flavor   string `toml:"flavor"`
+	CC       string `toml:"cc"`
+	CXX      string `toml:"cxx"`
+	Linker   string `toml:"ld"`
+	Archiver string `toml:"ar"`
+	Libtool  string `toml:"libtool"`
+	Nasm     string `toml:"nasm"`
+	Windres  string `toml:"windres"`
+
+	// CompilerLauncher prefixes the compile commands such as "ccache" and "distcc".
+	CompilerLauncher string `toml:"compiler_launcher"`
+
+	// UseResponseFiles passes the inputs of the archive and link rules
+	// via response files to avoid the limits of command-line length.
+	UseResponseFiles bool `toml:"use_response_files"`
+
+	// Features overrides the flags of the built-in features for the flavor.
+	Features map[string]Feature `toml:"features"`
+}
+
+const (
+	toolchainFlavorClang = "clang"
+	toolchainFlavorGCC   = "gcc"
+	toolchainFlavorMSVC  = "msvc"
+
+	// toolchainFlavorEmscripten compiles to WebAssembly and links executables into ".js" and ".wasm".
+	toolchainFlavorEmscripten = "emscripten"
+)
+
+// builtinFeatures maps the features to the flags for each flavor of toolchains.
+var builtinFeatures = map[string]map[string]Feature{
+	toolchainFlavorClang: {
+		"asan": {
+			CompilerFlags: []string{"-fsanitize=address", "-fno-omit-frame-pointer"},
+			LinkerFlags:   []string{"-fsanitize=address"},
+		},
+		"ubsan": {
+			CompilerFlags: []string{"-fsanitize=undefined"},
+			LinkerFlags:   []string{"-fsanitize=undefined"},
+		},
+		"tsan": {
+			CompilerFlags: []string{"-fsanitize=thread"},
+			LinkerFlags:   []string{"-fsanitize=thread"},
+		},
+		"coverage": {
+			CompilerFlags: []string{"-fprofile-instr-generate", "-fcoverage-mapping"},
+			LinkerFlags:   []string{"-fprofile-instr-generate"},
+		},
+		"lto": {
+			CompilerFlags: []string{"-flto"},
+			LinkerFlags:   []string{"-flto"},
+		},
+	},
+	toolchainFlavorGCC: {
+		"asan": {
+			CompilerFlags: []string{"-fsanitize=address", "-fno-omit-frame-pointer"},
+			LinkerFlags:   []string{"-fsanitize=address"},
+		},
+		"ubsan": {
+			CompilerFlags: []string{"-fsanitize=undefined"},
+			LinkerFlags:   []string{"-fsanitize=undefined"},
+		},
+		"tsan": {
+			CompilerFlags: []string{"-fsanitize=thread"},
+			LinkerFlags:   []string{"-fsanitize=thread"},
+		},
+		"coverage": {
+			CompilerFlags: []string{"--coverage"},
+			LinkerFlags:   []string{"--coverage"},
+		},
+		"lto": {
+			CompilerFlags: []string{"-flto"},
+			LinkerFlags:   []string{"-flto"},
+		},
+	},
+	toolchainFlavorEmscripten: {
+		"asan": {
+			CompilerFlags: []string{"-fsanitize=address"},
+			LinkerFlags:   []string{"-fsanitize=address"},
+		},
+		"ubsan": {
+			CompilerFlags: []string{"-fsanitize=undefined"},
+			LinkerFlags:   []string{"-fsanitize=undefined"},
+		},
+		"lto": {
+			CompilerFlags: []string{"-flto"},
+			LinkerFlags:   []string{"-flto"},
+		},
+	},
+	toolchainFlavorMSVC: {
+		"asan": {
+			CompilerFlags: []string{"/fsanitize=address"},
+			LinkerFlags:   []string{"/INFERASANLIBS"},
+		},
+		"lto": {
+			CompilerFlags: []string{"/GL"},
+			LinkerFlags:   []string{"/LTCG"},
+		},
+	},
+}
+
+// GetFeature gets the flags of the feature for the toolchain.
+func (toolchain *Toolchain) GetFeature(name string) (Feature, error) {
+	if feature, ok := toolchain.Features[name]; ok {
+		return feature, nil
+	}
+	flavor := toolchain.Flavor
+	if len(flavor) == 0 {
+		flavor = toolchainFlavorClang
+	}
+	if feature, ok := builtinFeatures[flavor][name]; ok {
+		return feature, nil
+	}
+	return Feature{}, errors.Errorf("Feature \"%s\" is not supported by toolchain \"%s\"", name, toolchain.Name)
+}
+
+const (
+	defaultToolchainName = "clang"
+	wasmToolchainName    = "wasm"
+	msvcToolchainName    = "msvc"
+)
+
+var builtinToolchains = []Toolchain{
+	{
+		Name:     "clang",
+		Flavor:   toolchainFlavorClang,
+		CC:       "clang",
+		CXX:      "clang++",
+		Linker:   "ld",
+		Archiver: "ar",
+		Libtool:  "libtool",
+		Nasm:     "nasm",
+		Windres:  "windres",
+	},
+	{
+		Name:     wasmToolchainName,
+		Flavor:   toolchainFlavorEmscripten,
+		CC:       "emcc",
+		CXX:      "em++",
+		Linker:   "em++",
+		Archiver: "emar",
+		Libtool:  "emar",
+		Nasm:     "nasm",
+		Windres:  "windres",
+	},
+	{
+		Name:     msvcToolchainName,
+		Flavor:   toolchainFlavorMSVC,
+		CC:       "cl",
+		CXX:      "cl",
+		Linker:   "link",
+		Archiver: "lib",
+		Libtool:  "lib",
+		Nasm:     "nasm",
+		Windres:  "rc",
+	},
+	{
+		Name:     "clang-cl",
+		Flavor:   toolchainFlavorMSVC,
+		CC:       "clang-cl",
+		CXX:      "clang-cl",
+		Linker:   "lld-link",
+		Archiver: "llvm-lib",
+		Libtool:  "llvm-lib",
+		Nasm:     "nasm",
+		Windres:  "llvm-rc",
+	},
+}
+
+func getBuiltinToolchain(name string) *Toolchain {
+	for i := range builtinToolchains {
+		if builtinToolchains[i].Name == name {
+			toolchain := builtinToolchains[i]
+			return &toolchain
+		}
+	}
+	return nil
+}
+
+// withDefaults fills the tools that are not specified with the built-in toolchain of the flavor.
+func (toolchain Toolchain) withDefaults() *Toolchain {
+	defaults := getBuiltinToolchain(defaultToolchainName)
+	switch toolchain.Flavor {
+	case toolchainFlavorEmscripten:
+		defaults = getBuiltinToolchain(wasmToolchainName)
+	case toolchainFlavorMSVC:
+		defaults = getBuiltinToolchain(msvcToolchainName)
+	}
+	fill := func(dst *string, src string) {
+		if len(*dst) == 0 {
+			*dst = src
+		}
+	}
+	fill(&toolchain.Flavor, defaults.Flavor)
+	fill(&toolchain.CC, defaults.CC)
+	fill(&toolchain.CXX, defaults.CXX)
+	fill(&toolchain.Linker, defaults.Linker)
+	fill(&toolchain.Archiver, defaults.Archiver)
+	fill(&toolchain.Libtool, defaults.Libtool)
+	fill(&toolchain.Nasm, defaults.Nasm)
+	fill(&toolchain.Windres, defaults.Windres)
+	return &toolchain
+}
+
+// getToolchain gets the toolchain defined in the manifests or the built-in one.
+func getToolchain(graph *Graph, name string) (*Toolchain, error) {
+	if len(name) == 0 {
+		name = defaultToolchainName
+	}
+	for _, toolchain := range graph.Toolchains {
+		if toolchain.Name == name {
+			return toolchain.withDefaults(), nil
+		}
+	}
+	if toolchain := getBuiltinToolchain(name); toolchain != nil {
+		return toolchain, nil
+	}
+	return nil, errors.Errorf("Toolchain \"%s\" is not defined", name)
+}
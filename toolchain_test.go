@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToolchainGetFeature(t *testing.T) {
+	clang := getBuiltinToolchain("clang")
+	feature, err := clang.GetFeature("lto")
+	if err != nil || !reflect.DeepEqual(feature.LinkerFlags, []string{"-flto"}) {
+		t.Errorf("Unexpected feature: %v", feature)
+	}
+
+	msvc := (&Toolchain{Name: "cl", Flavor: toolchainFlavorMSVC}).withDefaults()
+	feature, err = msvc.GetFeature("lto")
+	if err != nil || !reflect.DeepEqual(feature, Feature{CompilerFlags: []string{"/GL"}, LinkerFlags: []string{"/LTCG"}}) {
+		t.Errorf("Unexpected feature: %v", feature)
+	}
+	if _, err := msvc.GetFeature("tsan"); err == nil {
+		t.Errorf("MSVC must not support tsan")
+	}
+
+	custom := (&Toolchain{
+		Name: "custom",
+		Features: map[string]Feature{
+			"lto": {CompilerFlags: []string{"-flto=thin"}},
+		},
+	}).withDefaults()
+	feature, err = custom.GetFeature("lto")
+	if err != nil || !reflect.DeepEqual(feature.CompilerFlags, []string{"-flto=thin"}) {
+		t.Errorf("Unexpected feature: %v", feature)
+	}
+}